@@ -0,0 +1,187 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BundleIndex is the name of the post source in a page bundle.
+//
+// A directory holding a file by this name is a page bundle: a single
+// post, along with all other files in the directory, which are copied
+// next to the post's generated output.
+const BundleIndex = "index.md"
+
+// isMarkdown determines if the given file is a Markdown post source.
+func isMarkdown(file string) bool {
+	return strings.EqualFold(filepath.Ext(file), ".md")
+}
+
+// isHidden determines if the given file or directory name is hidden.
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// isBundle determines if the given directory is a page bundle.
+func isBundle(dir string) bool {
+	stat, err := os.Stat(filepath.Join(dir, BundleIndex))
+	return err == nil && !stat.IsDir()
+}
+
+// findAssets finds the files which belong with the given post. For a page
+// bundle, these are all other files in the bundle. For other posts, these
+// are the files next to the post source, which are not posts themselves.
+//
+// The returned paths are relative to the directory holding the post.
+func findAssets(root string, post *Post) ([]string, error) {
+	dir := filepath.Dir(filepath.Join(root, post.file))
+
+	if !post.bundle {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+
+		var list []string
+		for _, stat := range files {
+			name := stat.Name()
+			if !stat.IsDir() && !isHidden(name) && !isMarkdown(name) {
+				list = append(list, name)
+			}
+		}
+
+		return list, nil
+	}
+
+	var list []string
+
+	err := filepath.Walk(dir, func(file string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if file != dir && isHidden(stat.Name()) {
+			if stat.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if stat.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		if rel != BundleIndex {
+			list = append(list, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+
+	return list, err
+}
+
+// AssetURL returns the site-relative URL of the given asset of a post.
+// Assets are placed next to the post's output, so that relative links
+// to them keep working.
+func (p *Post) AssetURL(asset string) string {
+	dir := p.Path
+	if !strings.HasSuffix(dir, "/") {
+		dir = strings.TrimSuffix(path.Dir(dir), "/") + "/"
+	}
+	return dir + asset
+}
+
+// checkAssets ensures that no two assets are written to the same output
+// file, and that no asset overwrites a post or a page generated by the
+// site itself.
+func (s *Site) checkAssets() error {
+	generated := s.generatedFiles()
+	owners := make(map[string]string, len(s.Posts))
+
+	for _, post := range s.Posts {
+		owners[outputName(post.Path)] = post.file
+	}
+
+	var errs ErrorList
+
+	for _, post := range s.Posts {
+		dir := filepath.Dir(post.file)
+
+		for _, asset := range post.assets {
+			src := filepath.Join(dir, filepath.FromSlash(asset))
+			name := outputName(post.AssetURL(asset))
+
+			// Posts in the same directory share their assets.
+			if owner, ok := owners[name]; ok {
+				if owner != src {
+					errs = append(errs, newFileError(src, 0, "File is written to %s, as is %s.", name, owner))
+				}
+				continue
+			}
+
+			owners[name] = src
+
+			if page, ok := generated[name]; ok {
+				errs = append(errs, newFileError(src, 0, "File is written to %s, which holds %s.", name, page))
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// WriteAssets copies the assets of all posts next to their output.
+func WriteAssets(site *Site) error {
+	deploy := filepath.Join(site.Root, "deploy")
+
+	// Posts in the same directory share assets. Copy each one once.
+	files := make(map[string]string)
+
+	for _, post := range site.Posts {
+		dir := filepath.Join(site.Root, filepath.Dir(post.file))
+
+		for _, asset := range post.assets {
+			dst := OutputFile(deploy, post.AssetURL(asset))
+			files[dst] = filepath.Join(dir, filepath.FromSlash(asset))
+		}
+	}
+
+	list := make([]string, 0, len(files))
+	for dst := range files {
+		list = append(list, dst)
+	}
+
+	sort.Strings(list)
+
+	jobs := make([]func() error, 0, len(list))
+
+	for _, dst := range list {
+		dst := dst
+		jobs = append(jobs, func() error {
+			err := os.MkdirAll(filepath.Dir(dst), DirPermission)
+			if err != nil {
+				return err
+			}
+			return copyFile(site, files[dst], dst)
+		})
+	}
+
+	return runJobs(site.Config.Jobs, jobs)
+}
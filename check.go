@@ -0,0 +1,332 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"html"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// LinkCheck defines which links are validated after a build.
+type LinkCheck int
+
+// Known link checks.
+const (
+	CheckNone     LinkCheck = iota // Do not check links.
+	CheckInternal                  // Check links within the site.
+	CheckExternal                  // Check links within the site and to other sites.
+)
+
+var (
+	regHTMLSkip = regexp.MustCompile(`(?is)<!--.*?-->|(<script\b[^>]*>).*?</script>|(<style\b[^>]*>).*?</style>`)
+	regHTMLElem = regexp.MustCompile(`<([a-zA-Z][a-zA-Z0-9]*)(\s[^>]*)?>`)
+	regHTMLAttr = regexp.MustCompile(`(?i)\s(href|src|id|name)\s*=\s*("[^"]*"|'[^']*'|[^\s"'>]+)`)
+)
+
+// checkPage holds the links and anchors found in a generated page.
+type checkPage struct {
+	links   []string        // Values of href and src attributes.
+	anchors map[string]bool // Targets for fragment links.
+}
+
+// CheckSite validates the links in all HTML files in the site's deploy
+// directory. Links within the site must point to a generated file and,
+// if they hold a fragment, to an element with that ID. Links to other
+// sites are only checked if requested.
+//
+// Problems are reported by the post the page was generated from,
+// or by the output file for pages which are not posts.
+func CheckSite(site *Site, checks LinkCheck) error {
+	if checks == CheckNone {
+		return nil
+	}
+
+	deploy := filepath.Join(site.Root, "deploy")
+	files := make(map[string]bool)
+
+	var list []string
+
+	err := filepath.Walk(deploy, func(file string, stat os.FileInfo, err error) error {
+		if err != nil || stat.IsDir() {
+			return err
+		}
+
+		rel, err := filepath.Rel(deploy, file)
+		if err != nil {
+			return err
+		}
+
+		name := "/" + filepath.ToSlash(rel)
+		files[name] = true
+
+		if strings.EqualFold(path.Ext(name), ".html") {
+			list = append(list, name)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(list)
+
+	// Parse all pages first, so that we know their anchors.
+	pages := make(map[string]*checkPage, len(list))
+	parsed := make([]*checkPage, len(list))
+	jobs := make([]func() error, len(list))
+
+	for i, name := range list {
+		i, name := i, name
+		jobs[i] = func() error {
+			data, err := ioutil.ReadFile(filepath.Join(deploy, filepath.FromSlash(name)))
+			if err != nil {
+				return err
+			}
+
+			parsed[i] = parseCheckPage(data)
+			return nil
+		}
+	}
+
+	err = runJobs(site.Config.Jobs, jobs)
+	if err != nil {
+		return err
+	}
+
+	for i, name := range list {
+		pages[name] = parsed[i]
+	}
+
+	sources := checkSources(site, deploy)
+	base := siteBase(site.Config)
+
+	var errs []*linkError
+
+	external := make(map[string][]*linkError)
+
+	for _, name := range list {
+		source, post := sources[name]
+		if !post {
+			source = filepath.Join("deploy", filepath.FromSlash(name[1:]))
+		}
+
+		seen := make(map[string]bool)
+
+		for _, link := range pages[name].links {
+			if seen[link] {
+				continue
+			}
+
+			seen[link] = true
+
+			u, err := url.Parse(link)
+			if err != nil {
+				errs = append(errs, &linkError{source, post, link, "is not a valid URL"})
+				continue
+			}
+
+			if isExternal(u, base) {
+				if checks == CheckExternal {
+					u.Fragment = ""
+					target := u.String()
+					external[target] = append(external[target], &linkError{source, post, link, ""})
+				}
+				continue
+			}
+
+			if len(u.Scheme) > 0 && u.Scheme != "http" && u.Scheme != "https" {
+				continue
+			}
+
+			if reason := checkInternal(name, u, base, files, pages); len(reason) > 0 {
+				errs = append(errs, &linkError{source, post, link, reason})
+			}
+		}
+	}
+
+	if len(external) > 0 {
+		checker, err := NewLinkChecker(site.Root, site.Config.Jobs)
+		if err != nil {
+			return err
+		}
+
+		targets := make([]string, 0, len(external))
+		for target := range external {
+			targets = append(targets, target)
+		}
+
+		for target, err := range checker.Check(targets) {
+			for _, e := range external[target] {
+				e.reason = "is broken: " + err.Error()
+				errs = append(errs, e)
+			}
+		}
+
+		err = checker.Save()
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].source != errs[j].source {
+			return errs[i].source < errs[j].source
+		}
+		return errs[i].link < errs[j].link
+	})
+
+	result := make(ErrorList, len(errs))
+	for i, e := range errs {
+		var line int
+		if e.post {
+			line = site.linkLine(e.source, e.link)
+		}
+
+		result[i] = newFileError(e.source, line, "Link to %s %s.", e.link, e.reason)
+	}
+
+	result.Sort()
+	return result
+}
+
+// linkError describes a broken link found in a generated page.
+type linkError struct {
+	source string // Source of the page holding the link.
+	post   bool   // Source is a Markdown file, rather than a generated page.
+	link   string // The link, as written in the page.
+	reason string // Description of the problem.
+}
+
+// parseCheckPage finds the links and anchors in the given HTML document.
+// Contents of comments, scripts and stylesheets are ignored.
+func parseCheckPage(data []byte) *checkPage {
+	p := new(checkPage)
+	p.anchors = make(map[string]bool)
+
+	data = regHTMLSkip.ReplaceAll(data, []byte("$1$2"))
+
+	for _, elem := range regHTMLElem.FindAllSubmatch(data, -1) {
+		tag := strings.ToLower(string(elem[1]))
+
+		for _, attr := range regHTMLAttr.FindAllSubmatch(elem[2], -1) {
+			name := strings.ToLower(string(attr[1]))
+			value := html.UnescapeString(strings.Trim(string(attr[2]), `"'`))
+
+			switch {
+			case name == "href" || name == "src":
+				if len(strings.TrimSpace(value)) > 0 {
+					p.links = append(p.links, strings.TrimSpace(value))
+				}
+			case name == "id" || (name == "name" && tag == "a"):
+				p.anchors[value] = true
+			}
+		}
+	}
+
+	return p
+}
+
+// checkSources maps the output files of posts and the front page,
+// relative to the deploy directory, onto their source files.
+func checkSources(site *Site, deploy string) map[string]string {
+	sources := make(map[string]string, len(site.Posts)+1)
+	sources["/index.html"] = "index.md"
+
+	for _, post := range site.Posts {
+		rel, err := filepath.Rel(deploy, OutputFile(deploy, post.Path))
+		if err == nil {
+			sources["/"+filepath.ToSlash(rel)] = post.file
+		}
+	}
+
+	return sources
+}
+
+// siteBase returns the site URL, with a path ending in a slash.
+func siteBase(config *Config) *url.URL {
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		u = new(url.URL)
+	}
+
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	return u
+}
+
+// isExternal determines if the given link points to another site.
+func isExternal(u, base *url.URL) bool {
+	if len(u.Host) == 0 {
+		return false
+	}
+
+	if len(u.Scheme) > 0 && u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+
+	return !strings.EqualFold(u.Host, base.Host)
+}
+
+// checkInternal determines if the given link in the given page points to
+// an existing file and anchor. It returns a description of the problem,
+// or an empty string if the link is fine.
+func checkInternal(page string, u, base *url.URL, files map[string]bool, pages map[string]*checkPage) string {
+	target := page
+
+	if len(u.Path) > 0 {
+		ref := path.Join(path.Dir(page), u.Path)
+		if strings.HasPrefix(u.Path, "/") {
+			// The deploy directory is served at the path of the site
+			// URL, which ends in a slash. Strip it off, including for
+			// links to the site URL itself.
+			p := u.Path
+			if p+"/" == base.Path {
+				p = base.Path
+			}
+
+			if !strings.HasPrefix(p, base.Path) {
+				return "is outside the site"
+			}
+
+			ref = "/" + strings.TrimPrefix(p, base.Path)
+		}
+
+		target = path.Clean(ref)
+
+		if strings.HasSuffix(u.Path, "/") {
+			target = path.Join(target, "index.html")
+		} else if !files[target] && files[path.Join(target, "index.html")] {
+			target = path.Join(target, "index.html")
+		}
+
+		if !files[target] {
+			return "points to a missing file"
+		}
+	}
+
+	if len(u.Fragment) == 0 {
+		return ""
+	}
+
+	if p, ok := pages[target]; ok && !p.anchors[u.Fragment] {
+		return "points to a missing anchor"
+	}
+
+	return ""
+}
@@ -0,0 +1,66 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestCheckInternal(t *testing.T) {
+	files := map[string]bool{
+		"/index.html":          true,
+		"/posts/x/index.html":  true,
+		"/posts/x/diagram.png": true,
+		"/posts/y.html":        true,
+		"/tags/index.html":     true,
+		"/css/style.css":       true,
+	}
+
+	pages := map[string]*checkPage{
+		"/posts/y.html": {anchors: map[string]bool{"intro": true}},
+	}
+
+	tests := []struct {
+		site, page, link, want string
+	}{
+		// Site at the root of its host.
+		{"https://example.com", "/index.html", "/posts/x/", ""},
+		{"https://example.com", "/index.html", "/posts/x", ""},
+		{"https://example.com", "/index.html", "/posts/y.html#intro", ""},
+		{"https://example.com", "/index.html", "/posts/y.html#outro", "points to a missing anchor"},
+		{"https://example.com", "/index.html", "/posts/z.html", "points to a missing file"},
+		{"https://example.com", "/posts/y.html", "#intro", ""},
+		{"https://example.com", "/posts/y.html", "../tags/", ""},
+		{"https://example.com", "/posts/y.html", "x/diagram.png", ""},
+
+		// Site in a sub directory of its host.
+		{"https://example.com/blog/", "/index.html", "/blog/posts/x/", ""},
+		{"https://example.com/blog/", "/index.html", "/blog/posts/x", ""},
+		{"https://example.com/blog/", "/index.html", "/blog/posts/y.html#intro", ""},
+		{"https://example.com/blog/", "/index.html", "/blog/css/style.css", ""},
+		{"https://example.com/blog/", "/posts/y.html", "/blog/", ""},
+		{"https://example.com/blog/", "/posts/y.html", "/blog", ""},
+		{"https://example.com/blog", "/index.html", "/blog/tags/", ""},
+		{"https://example.com/blog/", "/posts/y.html", "../tags/", ""},
+		{"https://example.com/blog/", "/index.html", "/blog/posts/z.html", "points to a missing file"},
+		{"https://example.com/blog/", "/index.html", "/posts/x/", "is outside the site"},
+		{"https://example.com/blog/", "/index.html", "/blogger/", "is outside the site"},
+	}
+
+	for _, test := range tests {
+		u, err := url.Parse(test.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := NewConfig()
+		config.URL = test.site
+
+		got := checkInternal(test.page, u, siteBase(config), files, pages)
+		if got != test.want {
+			t.Errorf("%s: link %q in %s: have %q, want %q", test.site, test.link, test.page, got, test.want)
+		}
+	}
+}
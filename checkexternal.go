@@ -0,0 +1,269 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// LinkCacheFile is the name of the file which records working
+	// external links, relative to the site root.
+	LinkCacheFile = ".linkcache"
+
+	// LinkCacheAge defines how long an external link which was found
+	// to work, is assumed to keep working.
+	LinkCacheAge = 7 * 24 * time.Hour
+
+	// LinkTimeout defines how long to wait for a response to a request
+	// for an external link.
+	LinkTimeout = 15 * time.Second
+
+	// LinkInterval defines the minimum time between the start of two
+	// requests to the same host.
+	LinkInterval = time.Second
+
+	// LinkRetries defines how often a request for an external link is
+	// repeated, if the server responds with 429 Too Many Requests.
+	LinkRetries = 2
+
+	// LinkMaxDelay defines the longest delay before a repeated request,
+	// regardless of the delay requested by the server.
+	LinkMaxDelay = time.Minute
+)
+
+// LinkChecker checks external links by requesting them over HTTP.
+// Requests run concurrently, but are spread out over time for each
+// host. Links found to work are cached for LinkCacheAge.
+type LinkChecker struct {
+	Client   *http.Client         // Client used to perform requests.
+	Interval time.Duration        // Minimum time between requests to a host.
+	Jobs     int                  // Number of concurrent requests.
+	cache    map[string]time.Time // Working links, with the time they were checked.
+	hosts    map[string]time.Time // Earliest time of the next request, by host.
+	file     string               // Path to the cache file.
+	lock     sync.Mutex           // Guards cache and hosts.
+}
+
+// NewLinkChecker creates a new link checker for the site at the given
+// root. This loads the results of previous checks, if any. With an empty
+// root, results are not cached between runs.
+func NewLinkChecker(root string, jobs int) (*LinkChecker, error) {
+	c := new(LinkChecker)
+	c.Client = &http.Client{Timeout: LinkTimeout}
+	c.Interval = LinkInterval
+	c.Jobs = jobs
+	c.cache = make(map[string]time.Time)
+	c.hosts = make(map[string]time.Time)
+
+	if len(root) == 0 {
+		return c, nil
+	}
+
+	c.file = filepath.Join(root, LinkCacheFile)
+
+	data, err := ioutil.ReadFile(c.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, &c.cache)
+	if err != nil {
+		return nil, newError("%s: %v", c.file, err)
+	}
+
+	return c, nil
+}
+
+// Check requests the given links and returns the error for each
+// link which does not work.
+func (c *LinkChecker) Check(links []string) map[string]error {
+	errs := make([]error, len(links))
+	jobs := make([]func() error, 0, len(links))
+
+	for i, link := range links {
+		i, link := i, link
+		jobs = append(jobs, func() error {
+			errs[i] = c.check(link)
+			return nil
+		})
+	}
+
+	runJobs(c.Jobs, jobs)
+
+	broken := make(map[string]error)
+	for i, err := range errs {
+		if err != nil {
+			broken[links[i]] = err
+		}
+	}
+
+	return broken
+}
+
+// Save writes the links found to work to the cache file.
+// Links checked longer than LinkCacheAge ago are left out.
+func (c *LinkChecker) Save() error {
+	if len(c.file) == 0 {
+		return nil
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for link, checked := range c.cache {
+		if time.Since(checked) > LinkCacheAge {
+			delete(c.cache, link)
+		}
+	}
+
+	data, err := json.MarshalIndent(c.cache, "", " ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.file, data, FilePermission)
+}
+
+// check requests the given link. It returns an error if the request
+// fails, or if the server responds with an error status.
+//
+// A server which responds with 429 Too Many Requests is asked again,
+// once the delay it requests has passed. If it keeps refusing, the link
+// is not reported, but is not cached either, so it is checked again in
+// the next run.
+func (c *LinkChecker) check(link string) error {
+	c.lock.Lock()
+	checked, ok := c.cache[link]
+	c.lock.Unlock()
+
+	if ok && time.Since(checked) < LinkCacheAge {
+		return nil
+	}
+
+	u, err := url.Parse(link)
+	if err != nil {
+		return err
+	}
+
+	var resp *http.Response
+
+	for retry := 0; retry <= LinkRetries; retry++ {
+		// Not every server supports HEAD requests. Retry those
+		// which fail with a full GET request.
+		resp, err = c.request("HEAD", u)
+		if err != nil || (resp.StatusCode >= 400 && resp.StatusCode != http.StatusTooManyRequests) {
+			resp, err = c.request("GET", u)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests {
+			break
+		}
+
+		c.delay(u.Host, retryAfter(resp, c.Interval))
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return nil
+	case resp.StatusCode >= 400:
+		return newError("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode))
+	}
+
+	c.lock.Lock()
+	c.cache[link] = time.Now()
+	c.lock.Unlock()
+	return nil
+}
+
+// request performs a request for the given URL, once the rate limit
+// for its host allows it. The body of the response is closed.
+func (c *LinkChecker) request(method string, u *url.URL) (*http.Response, error) {
+	c.wait(u.Host)
+
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("User-Agent", fmt.Sprintf("%s/%d.%d", AppName, AppVersionMajor, AppVersionMinor))
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		if uerr, ok := err.(*url.Error); ok {
+			return nil, uerr.Err
+		}
+		return nil, err
+	}
+
+	resp.Body.Close()
+	return resp, nil
+}
+
+// wait blocks until a request to the given host may start.
+func (c *LinkChecker) wait(host string) {
+	c.lock.Lock()
+	now := time.Now()
+	next := c.hosts[host]
+	if next.Before(now) {
+		next = now
+	}
+	c.hosts[host] = next.Add(c.Interval)
+	c.lock.Unlock()
+
+	time.Sleep(next.Sub(now))
+}
+
+// delay postpones the next request to the given host, until at least
+// the given duration has passed.
+func (c *LinkChecker) delay(host string, d time.Duration) {
+	c.lock.Lock()
+	next := time.Now().Add(d)
+	if next.After(c.hosts[host]) {
+		c.hosts[host] = next
+	}
+	c.lock.Unlock()
+}
+
+// retryAfter returns the delay requested by the Retry-After header of
+// the given response, limited to LinkMaxDelay. It returns the given
+// fallback if the header is missing or invalid.
+func retryAfter(resp *http.Response, fallback time.Duration) time.Duration {
+	value := resp.Header.Get("Retry-After")
+
+	var d time.Duration
+	if n, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(n) * time.Second
+	} else if t, err := http.ParseTime(value); err == nil {
+		d = time.Until(t)
+	} else {
+		return fallback
+	}
+
+	if d < 0 {
+		d = 0
+	}
+
+	if d > LinkMaxDelay {
+		d = LinkMaxDelay
+	}
+
+	return d
+}
@@ -0,0 +1,216 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer records the requests made to a test HTTP server.
+type testServer struct {
+	*httptest.Server
+	requests []string    // Method and path of each request.
+	times    []time.Time // Time of each request.
+	lock     sync.Mutex
+}
+
+// newTestServer starts a server with the following paths:
+//
+//	/ok       responds with 200 OK.
+//	/nohead   responds with 405 to HEAD requests, and with 200 to GET.
+//	/missing  responds with 404 Not Found.
+//	/busy     always responds with 429 Too Many Requests.
+//	/busyonce responds with 429 to the first request, and with 200 after.
+func newTestServer(t *testing.T) *testServer {
+	s := new(testServer)
+
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.times = append(s.times, time.Now())
+		busy := s.count(r.URL.Path) == 1
+		s.lock.Unlock()
+
+		switch r.URL.Path {
+		case "/ok":
+		case "/nohead":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/busy":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/busyonce":
+			if busy {
+				w.WriteHeader(http.StatusTooManyRequests)
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+
+	t.Cleanup(s.Close)
+	return s
+}
+
+// count returns the number of requests made for the given path.
+// The caller must hold the lock.
+func (s *testServer) count(path string) int {
+	var n int
+	for _, r := range s.requests {
+		if r == "HEAD "+path || r == "GET "+path {
+			n++
+		}
+	}
+	return n
+}
+
+// Requests returns the requests made so far, and forgets them.
+func (s *testServer) Requests() ([]string, []time.Time) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	requests, times := s.requests, s.times
+	s.requests, s.times = nil, nil
+	return requests, times
+}
+
+// testChecker creates a link checker for the site at the given root,
+// with a short interval between requests.
+func testChecker(t *testing.T, root string) *LinkChecker {
+	c, err := NewLinkChecker(root, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.Interval = 20 * time.Millisecond
+	return c
+}
+
+func TestLinkCheckerStatus(t *testing.T) {
+	server := newTestServer(t)
+	c := testChecker(t, "")
+
+	links := []string{
+		server.URL + "/ok",
+		server.URL + "/nohead",
+		server.URL + "/missing",
+		server.URL + "/busy",
+		server.URL + "/busyonce",
+	}
+
+	broken := c.Check(links)
+
+	if len(broken) != 1 || broken[server.URL+"/missing"] == nil {
+		t.Fatalf("Expected only /missing to be broken, got %v.", broken)
+	}
+
+	if err := broken[server.URL+"/missing"]; err.Error() != "404 Not Found" {
+		t.Errorf("Unexpected error for /missing: %v", err)
+	}
+
+	requests, _ := server.Requests()
+
+	want := map[string]int{
+		"HEAD /nohead": 1,
+		"GET /nohead":  1,
+		"HEAD /busy":   LinkRetries + 1,
+		"GET /busy":    0,
+	}
+
+	for request, n := range want {
+		var got int
+		for _, r := range requests {
+			if r == request {
+				got++
+			}
+		}
+
+		if got != n {
+			t.Errorf("Expected %d requests %q, got %d: %v", n, request, got, requests)
+		}
+	}
+
+	// Links refused with 429 are only cached once they work.
+	if _, ok := c.cache[server.URL+"/busy"]; ok {
+		t.Error("Link refused with 429 was cached.")
+	}
+
+	if _, ok := c.cache[server.URL+"/busyonce"]; !ok {
+		t.Error("Link which worked after a 429 was not cached.")
+	}
+}
+
+func TestLinkCheckerInterval(t *testing.T) {
+	server := newTestServer(t)
+	c := testChecker(t, "")
+
+	links := []string{
+		server.URL + "/ok?1",
+		server.URL + "/ok?2",
+		server.URL + "/ok?3",
+		server.URL + "/ok?4",
+	}
+
+	if broken := c.Check(links); len(broken) > 0 {
+		t.Fatalf("Unexpected broken links: %v", broken)
+	}
+
+	_, times := server.Requests()
+	if len(times) != len(links) {
+		t.Fatalf("Expected %d requests, got %d.", len(links), len(times))
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+	// Requests start at the interval, but arrive at the server with some
+	// delay, which differs between them. Check the total span instead of
+	// the time between two requests, and leave room for that delay.
+	want := time.Duration(len(links)-1)*c.Interval - c.Interval/2
+
+	if d := times[len(times)-1].Sub(times[0]); d < want {
+		t.Errorf("Requests were spread over %v, expected at least %v.", d, want)
+	}
+}
+
+func TestLinkCheckerCache(t *testing.T) {
+	server := newTestServer(t)
+	root := t.TempDir()
+	links := []string{server.URL + "/ok", server.URL + "/missing"}
+
+	c := testChecker(t, root)
+	c.Check(links)
+
+	err := c.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server.Requests()
+
+	// Working links are loaded from the cache file, broken ones are
+	// requested again.
+	c = testChecker(t, root)
+	broken := c.Check(links)
+
+	if len(broken) != 1 || broken[server.URL+"/missing"] == nil {
+		t.Errorf("Expected only /missing to be broken, got %v.", broken)
+	}
+
+	requests, _ := server.Requests()
+	for _, r := range requests {
+		if r != "HEAD /missing" && r != "GET /missing" {
+			t.Errorf("Unexpected request %q for a cached link.", r)
+		}
+	}
+
+	if len(requests) == 0 {
+		t.Error("Broken link was not requested again.")
+	}
+}
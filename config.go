@@ -0,0 +1,232 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/jteeuwen/ini"
+)
+
+// ConfigFile is the name of the site configuration file,
+// relative to the site root.
+const ConfigFile = "site.ini"
+
+// Config holds site-wide settings.
+//
+// These are read from the site's configuration file, after which
+// they can be overridden through command line options.
+type Config struct {
+	Title          string            // Site title.
+	URL            string            // Base URL for the site.
+	Copyright      string            // Copyright notice.
+	Lang           string            // Default ISO language code.
+	Dir            string            // Default text direction.
+	Tags           string            // Default, comma-separated list of tags.
+	Keywords       string            // Default, comma-separated list of keywords.
+	FeedLength     int               // Maximum number of posts in a feed.
+	Drafts         bool              // Include posts marked as draft.
+	Future         bool              // Include posts with a future post date.
+	Expired        bool              // Include posts which have expired.
+	Jobs           int               // Number of concurrent build jobs.
+	PageSize       int               // Number of posts per post index page.
+	TagPageSize    int               // Number of posts per tag page.
+	IndexPageSize  int               // Number of posts per front page.
+	Permalink      string            // URL pattern for posts.
+	TagPermalink   string            // URL pattern for tag pages.
+	Theme          string            // Name of the theme in the themes directory.
+	HighlightStyle string            // Syntax highlighting style. Empty to disable.
+	LineNumbers    bool              // Show line numbers in code blocks.
+	HeadingAnchors bool              // Add permalink anchors to headings.
+	TOCDepth       int               // Deepest heading level in tables of contents.
+	SummaryLength  int               // Number of words in automatic summaries.
+	RelatedPosts   int               // Maximum number of related posts.
+	BrokenLinks    string            // Either "warn" or "error".
+	Strict         bool              // Treat warnings as errors.
+	Params         map[string]string // Arbitrary, user-defined parameters.
+
+	MinifyHTML   bool // Minify generated HTML pages.
+	MinifyCSS    bool // Minify static CSS files.
+	MinifyJS     bool // Minify static JavaScript files.
+	MinifySVG    bool // Minify static SVG images.
+	OptimizePNG  bool // Recompress static PNG images.
+	OptimizeJPEG bool // Losslessly recompress static JPEG images.
+}
+
+// NewConfig creates a new configuration with default settings.
+func NewConfig() *Config {
+	c := new(Config)
+	c.URL = "http://localhost"
+	c.Lang = "en,en-GB"
+	c.Dir = "ltr"
+	c.FeedLength = 10
+	c.Permalink = DefaultPermalink
+	c.TagPermalink = DefaultTagPermalink
+	c.HighlightStyle = DefaultHighlightStyle
+	c.TOCDepth = 3
+	c.SummaryLength = 70
+	c.RelatedPosts = 5
+	c.BrokenLinks = "warn"
+	c.Jobs = runtime.NumCPU()
+	c.Params = make(map[string]string)
+	c.MinifyHTML = true
+	c.MinifyCSS = true
+	c.MinifyJS = true
+	c.MinifySVG = true
+	c.OptimizePNG = true
+	c.OptimizeJPEG = true
+	return c
+}
+
+// validDir determines if the given value is a valid text direction.
+func validDir(value string) bool {
+	return value == "ltr" || value == "rtl" || value == "auto"
+}
+
+// LoadConfig loads the configuration file for the site at the given root.
+// If the file does not exist, the default configuration is returned.
+func LoadConfig(root string) (*Config, error) {
+	c := NewConfig()
+	path := filepath.Join(root, ConfigFile)
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, err
+	}
+
+	ini := ini.New()
+	err = ini.LoadBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range ini.Section("") {
+		err = c.Set(key, value)
+		if err != nil {
+			return nil, newError("%s: %v", path, err)
+		}
+	}
+
+	for key, value := range ini.Section("params") {
+		c.Params[key] = value
+	}
+
+	return c, nil
+}
+
+// Set assigns the given value to the setting with the given name.
+// It returns an error if the setting is unknown, or the value is invalid.
+func (c *Config) Set(key, value string) error {
+	var err error
+
+	switch strings.ToLower(key) {
+	case "title":
+		c.Title = value
+	case "url":
+		c.URL = value
+	case "copyright":
+		c.Copyright = value
+	case "lang":
+		c.Lang = value
+	case "dir":
+		c.Dir = value
+		if !validDir(value) {
+			err = newError("expected ltr, rtl or auto")
+		}
+	case "tags":
+		c.Tags = value
+	case "keywords":
+		c.Keywords = value
+	case "feedlength":
+		c.FeedLength, err = strconv.Atoi(value)
+	case "permalink":
+		c.Permalink = value
+	case "tagpermalink":
+		c.TagPermalink = value
+	case "theme":
+		c.Theme = value
+	case "highlightstyle":
+		c.HighlightStyle = value
+	case "linenos":
+		c.LineNumbers, err = strconv.ParseBool(value)
+	case "headinganchors":
+		c.HeadingAnchors, err = strconv.ParseBool(value)
+	case "tocdepth":
+		c.TOCDepth, err = strconv.Atoi(value)
+	case "summarylength":
+		c.SummaryLength, err = strconv.Atoi(value)
+	case "related":
+		c.RelatedPosts, err = strconv.Atoi(value)
+	case "brokenlinks":
+		c.BrokenLinks = strings.ToLower(value)
+		if c.BrokenLinks != "warn" && c.BrokenLinks != "error" {
+			err = newError("expected warn or error")
+		}
+	case "pagesize":
+		c.PageSize, err = strconv.Atoi(value)
+	case "tagpagesize":
+		c.TagPageSize, err = strconv.Atoi(value)
+	case "indexpagesize":
+		c.IndexPageSize, err = strconv.Atoi(value)
+	case "jobs":
+		c.Jobs, err = strconv.Atoi(value)
+	case "strict":
+		c.Strict, err = strconv.ParseBool(value)
+	case "drafts":
+		c.Drafts, err = strconv.ParseBool(value)
+	case "future":
+		c.Future, err = strconv.ParseBool(value)
+	case "expired":
+		c.Expired, err = strconv.ParseBool(value)
+	case "minifyhtml":
+		c.MinifyHTML, err = strconv.ParseBool(value)
+	case "minifycss":
+		c.MinifyCSS, err = strconv.ParseBool(value)
+	case "minifyjs":
+		c.MinifyJS, err = strconv.ParseBool(value)
+	case "minifysvg":
+		c.MinifySVG, err = strconv.ParseBool(value)
+	case "optimizepng":
+		c.OptimizePNG, err = strconv.ParseBool(value)
+	case "optimizejpeg":
+		c.OptimizeJPEG, err = strconv.ParseBool(value)
+	default:
+		return newError("Unknown configuration key %q.", key)
+	}
+
+	if err != nil {
+		return newError("Invalid value %q for configuration key %q.", value, key)
+	}
+
+	return nil
+}
+
+// DisableOptimizations turns off all output optimizations.
+func (c *Config) DisableOptimizations() {
+	c.MinifyHTML = false
+	c.MinifyCSS = false
+	c.MinifyJS = false
+	c.MinifySVG = false
+	c.OptimizePNG = false
+	c.OptimizeJPEG = false
+}
+
+// Param returns the user-defined parameter with the given name.
+// Returns an empty string if it does not exist.
+func (c *Config) Param(key string) string {
+	return c.Params[key]
+}
+
+// AbsURL turns the given site-relative path into an absolute URL.
+func (c *Config) AbsURL(path string) string {
+	return strings.TrimRight(c.URL, "/") + "/" + strings.TrimLeft(path, "/")
+}
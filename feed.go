@@ -0,0 +1,285 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	atomNamespace    = "http://www.w3.org/2005/Atom"
+	contentNamespace = "http://purl.org/rss/1.0/modules/content/"
+)
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomText struct {
+	Type string `xml:"type,attr,omitempty"`
+	Body string `xml:",chardata"`
+}
+
+type atomEntry struct {
+	Title     string    `xml:"title"`
+	ID        string    `xml:"id"`
+	Link      atomLink  `xml:"link"`
+	Published string    `xml:"published"`
+	Updated   string    `xml:"updated"`
+	Summary   *atomText `xml:"summary,omitempty"`
+	Content   *atomText `xml:"content,omitempty"`
+}
+
+type atomFeed struct {
+	XMLName xml.Name     `xml:"feed"`
+	XMLNS   string       `xml:"xmlns,attr"`
+	Title   string       `xml:"title"`
+	ID      string       `xml:"id"`
+	Links   []atomLink   `xml:"link"`
+	Updated string       `xml:"updated"`
+	Entries []*atomEntry `xml:"entry"`
+}
+
+type rssGuid struct {
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+	Value       string `xml:",chardata"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	Guid        rssGuid `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Description string  `xml:"description,omitempty"`
+	Content     string  `xml:"content:encoded,omitempty"`
+}
+
+type rssChannel struct {
+	Title         string     `xml:"title"`
+	Link          string     `xml:"link"`
+	Description   string     `xml:"description"`
+	AtomLink      atomLink   `xml:"atom:link"`
+	LastBuildDate string     `xml:"lastBuildDate,omitempty"`
+	Items         []*rssItem `xml:"item"`
+}
+
+type rssFeed struct {
+	XMLName      xml.Name    `xml:"rss"`
+	Version      string      `xml:"version,attr"`
+	XMLNSAtom    string      `xml:"xmlns:atom,attr"`
+	XMLNSContent string      `xml:"xmlns:content,attr"`
+	Channel      *rssChannel `xml:"channel"`
+}
+
+// WriteFeeds generates Atom and RSS feeds for the whole site, as well
+// as for each individual tag.
+func WriteFeeds(site *Site) error {
+	dst := filepath.Join(site.Root, "deploy")
+
+	jobs := make([]func() error, 0, len(site.Tags)+1)
+
+	jobs = append(jobs, func() error {
+		return writeFeeds(dst, "/", site.Config.Title, site.Posts, site)
+	})
+
+	for _, tag := range site.Tags {
+		tag := tag
+		jobs = append(jobs, func() error {
+			title := site.Config.Title + ": " + tag.Name
+			posts := site.FindPosts(tag)
+
+			base := URLDir(site.Config.TagPath(tag))
+			return writeFeeds(dst, base, title, posts, site)
+		})
+	}
+
+	return runJobs(site.Config.Jobs, jobs)
+}
+
+// writeFeeds writes both the Atom and RSS feed for the given posts
+// into the deploy directory. Base denotes the URL path of the directory
+// which holds the feeds.
+func writeFeeds(deploy, base, title string, posts []*Post, site *Site) error {
+	posts = recentPosts(posts, site.Config.FeedLength)
+	path := filepath.Join(deploy, filepath.FromSlash(base))
+
+	err := os.MkdirAll(path, DirPermission)
+	if err != nil {
+		return err
+	}
+
+	inputs := site.Inputs("", posts...)
+	atom := filepath.Join(path, "feed.atom")
+	rss := filepath.Join(path, "feed.rss")
+
+	if site.manifest.Stale(atom, inputs) {
+		err = writeXML(atom, newAtomFeed(base, title, posts, site.Config))
+		if err != nil {
+			return err
+		}
+	}
+
+	if site.manifest.Stale(rss, inputs) {
+		err = writeXML(rss, newRSSFeed(base, title, posts, site.Config))
+	}
+
+	return err
+}
+
+// newAtomFeed creates an Atom feed for the given posts.
+func newAtomFeed(base, title string, posts []*Post, config *Config) *atomFeed {
+	self := config.AbsURL(base + "feed.atom")
+
+	f := new(atomFeed)
+	f.XMLNS = atomNamespace
+	f.Title = title
+	f.ID = self
+	f.Links = []atomLink{
+		{Href: self, Rel: "self", Type: "application/atom+xml"},
+		{Href: config.AbsURL(base), Rel: "alternate", Type: "text/html"},
+	}
+	f.Updated = feedUpdated(posts).Format(time.RFC3339)
+	f.Entries = make([]*atomEntry, 0, len(posts))
+
+	for _, post := range posts {
+		url := config.AbsURL(post.Path)
+
+		entry := &atomEntry{
+			Title:     post.Title,
+			ID:        entryID(post, config),
+			Link:      atomLink{Href: url, Rel: "alternate", Type: "text/html"},
+			Published: post.Date.UTC().Format(time.RFC3339),
+			Updated:   post.LastModified().UTC().Format(time.RFC3339),
+			Content:   &atomText{Type: "html", Body: absLinks(string(post.Content), url)},
+		}
+
+		if len(post.Description) > 0 {
+			entry.Summary = &atomText{Type: "text", Body: post.Description}
+		} else if len(post.Summary) > 0 {
+			entry.Summary = &atomText{Type: "html", Body: absLinks(string(post.Summary), url)}
+		}
+
+		f.Entries = append(f.Entries, entry)
+	}
+
+	return f
+}
+
+// newRSSFeed creates an RSS 2.0 feed for the given posts.
+func newRSSFeed(base, title string, posts []*Post, config *Config) *rssFeed {
+	c := new(rssChannel)
+	c.Title = title
+	c.Link = config.AbsURL(base)
+	c.Description = title
+	c.AtomLink = atomLink{
+		Href: config.AbsURL(base + "feed.rss"),
+		Rel:  "self",
+		Type: "application/rss+xml",
+	}
+	if updated := feedUpdated(posts); !updated.IsZero() {
+		c.LastBuildDate = updated.Format(time.RFC1123Z)
+	}
+	c.Items = make([]*rssItem, 0, len(posts))
+
+	for _, post := range posts {
+		url := config.AbsURL(post.Path)
+
+		description := post.Description
+		if len(description) == 0 {
+			description = absLinks(string(post.Summary), url)
+		}
+
+		c.Items = append(c.Items, &rssItem{
+			Title:       post.Title,
+			Link:        url,
+			Guid:        rssGuid{IsPermaLink: false, Value: entryID(post, config)},
+			PubDate:     post.Date.UTC().Format(time.RFC1123Z),
+			Description: description,
+			Content:     absLinks(string(post.Content), url),
+		})
+	}
+
+	return &rssFeed{
+		Version:      "2.0",
+		XMLNSAtom:    atomNamespace,
+		XMLNSContent: contentNamespace,
+		Channel:      c,
+	}
+}
+
+// entryID returns the ID of the given post in feeds. This is a tag URI
+// made up of the site's host, the post date and the post's source file,
+// so that it does not change along with the post URL.
+func entryID(post *Post, config *Config) string {
+	host := "localhost"
+	if u, err := url.Parse(config.URL); err == nil && len(u.Hostname()) > 0 {
+		host = u.Hostname()
+	}
+
+	file := &url.URL{Path: filepath.ToSlash(post.file)}
+	return fmt.Sprintf("tag:%s,%s:%s", host, post.Date.UTC().Format(DayFormat), file.EscapedPath())
+}
+
+// absLinks makes the links in the given HTML absolute, relative to the
+// given URL of the page holding it. Feed readers resolve relative links
+// against the feed's URL, which breaks links to a post's assets.
+func absLinks(data, base string) string {
+	u, err := url.Parse(base)
+	if err != nil {
+		return data
+	}
+
+	return regLink.ReplaceAllStringFunc(data, func(attr string) string {
+		m := regLink.FindStringSubmatch(attr)
+
+		ref, err := url.Parse(html.UnescapeString(m[2]))
+		if err != nil || ref.IsAbs() {
+			return attr
+		}
+
+		return m[1] + html.EscapeString(u.ResolveReference(ref).String()) + m[3]
+	})
+}
+
+// writeXML writes the given value as an XML document to the given file.
+func writeXML(path string, v interface{}) error {
+	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePermission)
+	if err != nil {
+		return err
+	}
+
+	defer fd.Close()
+
+	_, err = fd.WriteString(xml.Header)
+	if err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(fd)
+	enc.Indent("", " ")
+	return enc.Encode(v)
+}
+
+// feedUpdated returns the most recent modification time of the given
+// posts. This is the zero time if there are no posts, so that the feed
+// does not change between builds.
+func feedUpdated(posts []*Post) time.Time {
+	var t time.Time
+
+	for _, post := range posts {
+		if post.LastModified().After(t) {
+			t = post.LastModified()
+		}
+	}
+
+	return t.UTC()
+}
@@ -0,0 +1,28 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import "testing"
+
+func TestAbsLinks(t *testing.T) {
+	const base = "https://example.com/blog/posts/2014/01/07/bundle.html"
+
+	tests := []struct {
+		in, want string
+	}{
+		{`<img src="images/square.svg">`, `<img src="https://example.com/blog/posts/2014/01/07/images/square.svg">`},
+		{`<a href="../other.html">`, `<a href="https://example.com/blog/posts/2014/01/other.html">`},
+		{`<a href="/blog/tags/misc/">`, `<a href="https://example.com/blog/tags/misc/">`},
+		{`<a href="#intro">`, `<a href="https://example.com/blog/posts/2014/01/07/bundle.html#intro">`},
+		{`<a href="a.html?x=1&amp;y=2">`, `<a href="https://example.com/blog/posts/2014/01/07/a.html?x=1&amp;y=2">`},
+		{`<a href="https://other.org/x">`, `<a href="https://other.org/x">`},
+		{`<a href="mailto:me@example.com">`, `<a href="mailto:me@example.com">`},
+	}
+
+	for _, test := range tests {
+		if got := absLinks(test.in, base); got != test.want {
+			t.Errorf("absLinks(%q):\nhave %s\nwant %s", test.in, got, test.want)
+		}
+	}
+}
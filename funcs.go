@@ -0,0 +1,325 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"net/url"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+var regHTMLTag = regexp.MustCompile(`<[^>]*>`)
+
+// templateFuncs returns the functions available to all site templates.
+//
+//	date        Formats a time with the given layout: {{date "2006-01-02" .Date}}
+//	absURL      Turns a site-relative path into an absolute URL.
+//	relURL      Turns a site-relative path into a root-relative URL,
+//	            taking the path of the site URL into account.
+//	markdownify Renders Markdown to HTML.
+//	truncate    Shortens text to at most n characters: {{truncate 100 .Description}}
+//	wordCount   Counts the words in a piece of text or HTML.
+//	first       Returns the first n elements of a list.
+//	last        Returns the last n elements of a list.
+//	where       Selects the list elements whose field equals a value:
+//	            {{where .Site.Posts "Lang" "nl"}}
+//	sortBy      Sorts a list by a field: {{sortBy .Site.Posts "Title" "desc"}}
+//	tagURL      Returns the URL of the page for the given tag or tag name.
+func templateFuncs(site *Site) template.FuncMap {
+	config := site.Config
+
+	return template.FuncMap{
+		"date":        formatDate,
+		"absURL":      config.AbsURL,
+		"relURL":      config.RelURL,
+		"markdownify": site.markdownify,
+		"truncate":    truncate,
+		"wordCount":   wordCount,
+		"first":       first,
+		"last":        last,
+		"where":       where,
+		"sortBy":      sortBy,
+		"tagURL": func(tag interface{}) (string, error) {
+			switch tv := tag.(type) {
+			case Tag:
+				return config.TagPath(tv), nil
+			case string:
+				return config.TagPath(NewTag(tv)), nil
+			}
+			return "", newError("tagURL: unsupported type %T", tag)
+		},
+	}
+}
+
+// RelURL turns the given site-relative path into a root-relative URL.
+// This includes the path component of the site URL, if there is one.
+func (c *Config) RelURL(p string) string {
+	base := "/"
+	if u, err := url.Parse(c.URL); err == nil && len(u.Path) > 0 {
+		base = u.Path
+	}
+
+	rel := path.Join("/", base, p)
+	if strings.HasSuffix(p, "/") && rel != "/" {
+		rel += "/"
+	}
+
+	return rel
+}
+
+// markdownify renders the given Markdown text as HTML.
+func (s *Site) markdownify(text string) (template.HTML, error) {
+	data, err := s.Markdown([]byte(text), "markdownify")
+	return template.HTML(data), err
+}
+
+// formatDate formats the given time with the given layout.
+// Times may also be given as strings in the TimeFormat layout.
+// An empty layout selects DateFormat.
+func formatDate(layout string, value interface{}) (string, error) {
+	if len(layout) == 0 {
+		layout = DateFormat
+	}
+
+	switch tv := value.(type) {
+	case time.Time:
+		return tv.Format(layout), nil
+	case *time.Time:
+		return tv.Format(layout), nil
+	case string:
+		t, err := time.Parse(TimeFormat, tv)
+		if err != nil {
+			return "", err
+		}
+		return t.Format(layout), nil
+	}
+
+	return "", newError("date: unsupported type %T", value)
+}
+
+// truncate shortens the given text to at most n characters. It cuts at
+// a word boundary where possible and appends an ellipsis. HTML markup
+// is stripped from the text.
+func truncate(n int, value interface{}) string {
+	text := plainText(value)
+	if n <= 0 || utf8.RuneCountInString(text) <= n {
+		return text
+	}
+
+	runes := []rune(text)[:n]
+	text = string(runes)
+
+	if index := strings.LastIndexAny(text, " \t\r\n"); index > 0 {
+		text = text[:index]
+	}
+
+	return strings.TrimSpace(text) + "…"
+}
+
+// wordCount counts the words in the given text. HTML markup is not
+// counted.
+func wordCount(value interface{}) int {
+	return len(strings.Fields(plainText(value)))
+}
+
+// plainText returns the given string, byte slice or HTML as plain
+// text. HTML content, including post contents, has its markup removed.
+func plainText(value interface{}) string {
+	switch tv := value.(type) {
+	case string:
+		return tv
+	case template.HTML:
+		return stripHTML(string(tv))
+	case template.HTMLAttr:
+		return html.UnescapeString(string(tv))
+	case []byte:
+		return stripHTML(string(tv))
+	}
+	return fmt.Sprint(value)
+}
+
+// stripHTML removes all markup from the given HTML.
+func stripHTML(s string) string {
+	return html.UnescapeString(regHTMLTag.ReplaceAllString(s, " "))
+}
+
+// first returns the first n elements of the given list.
+func first(n int, list interface{}) (interface{}, error) {
+	v, err := listValue("first", list)
+	if err != nil {
+		return nil, err
+	}
+
+	if n >= 0 && n < v.Len() {
+		v = v.Slice(0, n)
+	}
+
+	return v.Interface(), nil
+}
+
+// last returns the last n elements of the given list.
+func last(n int, list interface{}) (interface{}, error) {
+	v, err := listValue("last", list)
+	if err != nil {
+		return nil, err
+	}
+
+	if n >= 0 && n < v.Len() {
+		v = v.Slice(v.Len()-n, v.Len())
+	}
+
+	return v.Interface(), nil
+}
+
+// where returns a new list holding the elements of the given list,
+// whose named field or method yields the given value.
+func where(list interface{}, name string, value interface{}) (interface{}, error) {
+	v, err := listValue("where", list)
+	if err != nil {
+		return nil, err
+	}
+
+	out := reflect.MakeSlice(v.Type(), 0, v.Len())
+	want := fmt.Sprint(value)
+
+	for i := 0; i < v.Len(); i++ {
+		field, err := fieldValue(v.Index(i), name)
+		if err != nil {
+			return nil, newError("where: %v", err)
+		}
+
+		if fmt.Sprint(field.Interface()) == want {
+			out = reflect.Append(out, v.Index(i))
+		}
+	}
+
+	return out.Interface(), nil
+}
+
+// sortBy returns a copy of the given list, sorted by the named field or
+// method. The optional order is either "asc" (default) or "desc".
+func sortBy(list interface{}, name string, order ...string) (interface{}, error) {
+	v, err := listValue("sortBy", list)
+	if err != nil {
+		return nil, err
+	}
+
+	desc := len(order) > 0 && strings.EqualFold(order[0], "desc")
+
+	keys := make([]reflect.Value, v.Len())
+	for i := range keys {
+		keys[i], err = fieldValue(v.Index(i), name)
+		if err != nil {
+			return nil, newError("sortBy: %v", err)
+		}
+	}
+
+	index := make([]int, v.Len())
+	for i := range index {
+		index[i] = i
+	}
+
+	sort.SliceStable(index, func(i, j int) bool {
+		if desc {
+			return lessValue(keys[index[j]], keys[index[i]])
+		}
+		return lessValue(keys[index[i]], keys[index[j]])
+	})
+
+	out := reflect.MakeSlice(v.Type(), 0, v.Len())
+	for _, i := range index {
+		out = reflect.Append(out, v.Index(i))
+	}
+
+	return out.Interface(), nil
+}
+
+// listValue returns the given list as a reflected slice.
+func listValue(fn string, list interface{}) (reflect.Value, error) {
+	v := reflect.ValueOf(list)
+	switch v.Kind() {
+	case reflect.Slice:
+		return v, nil
+	case reflect.Array:
+		out := reflect.MakeSlice(reflect.SliceOf(v.Type().Elem()), v.Len(), v.Len())
+		reflect.Copy(out, v)
+		return out, nil
+	}
+	return v, newError("%s: expected a list, got %T", fn, list)
+}
+
+// fieldValue returns the value of the named field, or the result of
+// the named method without arguments, for the given value.
+func fieldValue(v reflect.Value, name string) (reflect.Value, error) {
+	for v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+
+	if m := v.MethodByName(name); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() >= 1 {
+		return m.Call(nil)[0], nil
+	}
+
+	for v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return v, newError("nil element")
+		}
+		v = v.Elem()
+	}
+
+	if v.Kind() == reflect.Struct {
+		if f := v.FieldByName(name); f.IsValid() && f.CanInterface() {
+			return f, nil
+		}
+	}
+
+	if v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String {
+		if f := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key())); f.IsValid() {
+			return f, nil
+		}
+		return reflect.Zero(v.Type().Elem()), nil
+	}
+
+	return v, newError("%s has no field %q", v.Type(), name)
+}
+
+// lessValue determines if a sorts before b.
+func lessValue(a, b reflect.Value) bool {
+	for a.Kind() == reflect.Interface && !a.IsNil() {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface && !b.IsNil() {
+		b = b.Elem()
+	}
+
+	if a.Kind() != b.Kind() {
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	}
+
+	if ta, ok := a.Interface().(time.Time); ok {
+		if tb, ok := b.Interface().(time.Time); ok {
+			return ta.Before(tb)
+		}
+	}
+
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	}
+
+	return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+}
@@ -0,0 +1,260 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+)
+
+// DefaultHighlightStyle defines the default style for syntax highlighting.
+const DefaultHighlightStyle = "github"
+
+// HighlightFile is the site-relative path of the generated stylesheet,
+// which defines the colours for highlighted code.
+const HighlightFile = "/css/highlight.css"
+
+// Highlighter renders fenced code blocks with syntax highlighting.
+// Tokens are marked up with CSS classes, whose colours are defined in
+// a stylesheet generated for the configured style.
+//
+// A nil Highlighter leaves all code blocks unchanged.
+type Highlighter struct {
+	style       *chroma.Style
+	lineNumbers bool
+}
+
+// codeOptions holds the settings for a single code block. They are read
+// from the info string of a fenced code block, which holds the language
+// and optional settings, separated by spaces:
+//
+//	```go linenos hl_lines=2-4,7
+//
+// Recognised settings are:
+//
+//	linenos           Show line numbers. Use linenos=false to hide them.
+//	linenostart=<n>   Number of the first line.
+//	hl_lines=<lines>  Comma-separated list of lines or ranges to highlight.
+//	                  Line numbers are relative to linenostart.
+type codeOptions struct {
+	lang        string
+	lineNumbers bool
+	start       int
+	lines       [][2]int
+}
+
+// NewHighlighter creates a highlighter for the given configuration.
+// It returns nil if highlighting is disabled.
+func NewHighlighter(config *Config) (*Highlighter, error) {
+	if len(config.HighlightStyle) == 0 {
+		return nil, nil
+	}
+
+	style, ok := styles.Registry[strings.ToLower(config.HighlightStyle)]
+	if !ok {
+		return nil, newError("Unknown highlight style %q.", config.HighlightStyle)
+	}
+
+	h := new(Highlighter)
+	h.style = style
+	h.lineNumbers = config.LineNumbers
+	return h, nil
+}
+
+// Highlight renders the given code, using the given settings, as read
+// from a fenced code block info string by parseInfo. It returns false if
+// the code could not be highlighted, because the language is not known.
+func (h *Highlighter) Highlight(out *bytes.Buffer, code []byte, opt *codeOptions) (bool, error) {
+	if h == nil {
+		return false, nil
+	}
+
+	lexer := lexers.Get(opt.lang)
+	if lexer == nil {
+		return false, nil
+	}
+
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, string(code))
+	if err != nil {
+		return false, err
+	}
+
+	format := chromahtml.New(
+		chromahtml.WithClasses(true),
+		chromahtml.WithLineNumbers(opt.lineNumbers),
+		chromahtml.LineNumbersInTable(true),
+		chromahtml.BaseLineNumber(opt.start),
+		chromahtml.HighlightLines(opt.lines),
+	)
+
+	var buf bytes.Buffer
+
+	err = format.Format(&buf, h.style, tokens)
+	if err != nil {
+		return false, err
+	}
+
+	out.Write(buf.Bytes())
+	return true, nil
+}
+
+// WriteCSS writes the stylesheet for the highlighter's style.
+func (h *Highlighter) WriteCSS(w io.Writer) error {
+	format := chromahtml.New(chromahtml.WithClasses(true))
+	return format.WriteCSS(w, h.style)
+}
+
+// Style returns the name of the highlight style.
+func (h *Highlighter) Style() string { return h.style.Name }
+
+// parseInfo reads the language and settings from the given fenced
+// code block info string. Unknown options and invalid values are
+// ignored. A description of each is returned along with the settings.
+func (h *Highlighter) parseInfo(info string) (*codeOptions, []string) {
+	opt := &codeOptions{
+		lineNumbers: h.lineNumbers,
+		start:       1,
+	}
+
+	fields := strings.Fields(info)
+	if len(fields) == 0 {
+		return opt, nil
+	}
+
+	opt.lang = fields[0]
+
+	var problems []string
+
+	for _, field := range fields[1:] {
+		var err error
+		key, value := field, ""
+
+		if index := strings.IndexByte(field, '='); index > -1 {
+			key, value = field[:index], field[index+1:]
+		}
+
+		switch key {
+		case "linenos":
+			lineNumbers := true
+			if len(value) > 0 {
+				lineNumbers, err = strconv.ParseBool(value)
+			}
+			if err == nil {
+				opt.lineNumbers = lineNumbers
+			}
+		case "linenostart":
+			var start int
+			start, err = strconv.Atoi(value)
+			if err == nil {
+				opt.start = start
+			}
+		case "hl_lines":
+			var lines [][2]int
+			lines, err = parseLineRanges(value)
+			if err == nil {
+				opt.lines = lines
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("Ignoring unknown code block option %q.", key))
+			continue
+		}
+
+		if err != nil {
+			problems = append(problems, fmt.Sprintf(
+				"Ignoring invalid value %q for code block option %q.", value, key))
+		}
+	}
+
+	// Highlighted lines are given relative to the first line number.
+	for i := range opt.lines {
+		opt.lines[i][0] += opt.start - 1
+		opt.lines[i][1] += opt.start - 1
+	}
+
+	return opt, problems
+}
+
+// parseLineRanges parses a comma-separated list of line numbers
+// and ranges, like "2-4,7".
+func parseLineRanges(value string) ([][2]int, error) {
+	var list [][2]int
+
+	for _, part := range strings.Split(value, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+
+		from, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, err
+		}
+
+		to := from
+		if len(bounds) > 1 {
+			to, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		list = append(list, [2]int{from, to})
+	}
+
+	return list, nil
+}
+
+// WriteHighlightCSS writes the stylesheet for highlighted code, unless
+// highlighting is disabled or the site supplies its own stylesheet in
+// the static directory.
+func WriteHighlightCSS(site *Site) error {
+	h := site.highlighter
+	if h == nil {
+		return nil
+	}
+
+	file := filepath.FromSlash(HighlightFile)
+
+	_, err := os.Stat(filepath.Join(site.Root, "static", file))
+	if err == nil {
+		return nil
+	}
+
+	path := filepath.Join(site.Root, "deploy", file)
+	inputs := Inputs{
+		"style":    h.Style(),
+		"optimize": site.optimizer.Settings(),
+	}
+
+	if !site.manifest.Stale(path, inputs) {
+		return nil
+	}
+
+	var buf bytes.Buffer
+
+	err = h.WriteCSS(&buf)
+	if err != nil {
+		return err
+	}
+
+	data, err := site.optimizer.Optimize(path, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), DirPermission)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, FilePermission)
+}
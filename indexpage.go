@@ -0,0 +1,23 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+// IndexPage represents the front page. It displays the contents
+// of index.md, along with a listing of recent posts.
+type IndexPage struct {
+	*PostPage
+	posts []*Post
+}
+
+// NewIndexPage returns a new IndexPage for the given front page post.
+// It lists the given posts, which are expected to be sorted by date.
+func NewIndexPage(site *Site, post *Post, posts []*Post, pager *Paginator) *IndexPage {
+	p := new(IndexPage)
+	p.PostPage = NewPostPage(site, post)
+	p.Page.paginator = pager
+	p.posts = posts
+	return p
+}
+
+func (p *IndexPage) Posts() []*Post { return p.posts }
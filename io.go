@@ -4,17 +4,21 @@
 package main
 
 import (
-	"io"
+	"bytes"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
-
-	"github.com/jteeuwen/blackfriday"
 )
 
 // WriteIndex writes the front page.
-// This is a special version of a normal Post.
+// This is a special version of a normal Post, which additionally
+// lists recent posts. This listing can span multiple pages.
+//
+// The content of index.md is only rendered if a page needs to be
+// written. Until then, links in it cannot be resolved, so the pages
+// depend on the sources of all posts, rather than on the linked ones.
 func WriteIndex(site *Site) error {
 	path := filepath.Join(site.Root, "index.md")
 	data, err := ioutil.ReadFile(path)
@@ -22,7 +26,9 @@ func WriteIndex(site *Site) error {
 		return err
 	}
 
-	post := NewPost()
+	post := NewPost(site.Config)
+	post.file = "index.md"
+	post.hash = hashBytes(data)
 
 	// Check if we have meta data.
 	data, _, err = post.ReadMetadata(data)
@@ -30,128 +36,232 @@ func WriteIndex(site *Site) error {
 		return err
 	}
 
-	// Parse content as markdown.
-	post.Content = blackfriday.MarkdownCommon(data)
+	err = site.checkLayout(post)
+	if err != nil {
+		return err
+	}
 
-	// Generate output.
-	path = filepath.Join(site.Root, "deploy")
-	path = filepath.Join(path, "index.html")
+	deploy := filepath.Join(site.Root, "deploy")
+	pages := Paginate(recentPosts(site.Posts, 0), site.Config.IndexPageSize)
+	name := post.Template("index.html")
+	rendered := false
 
-	page := NewPostPage(post)
+	for i, posts := range pages {
+		pager := NewPaginator("/", i+1, len(pages))
+		path := OutputFile(deploy, pager.URL(i+1))
 
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePermission)
+		inputs := pageInputs(site, name, pages, append([]*Post{post}, posts...)...)
+		inputs["site"] = site.siteHash
+
+		if !site.manifest.Stale(path, inputs) {
+			continue
+		}
+
+		if !rendered {
+			err = renderIndex(site, post, data)
+			if err != nil {
+				return err
+			}
+			rendered = true
+		}
+
+		page := NewIndexPage(site, post, posts, pager)
+
+		err = renderPage(site, path, name, page)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renderIndex renders the given content of index.md into the front
+// page's post and resolves the links in it.
+func renderIndex(site *Site, post *Post, data []byte) error {
+	var err error
+
+	// Parse content as markdown.
+	post.Content, err = site.Markdown(data, post.file)
 	if err != nil {
 		return err
 	}
 
-	defer fd.Close()
+	post.Content = bytes.Replace(post.Content, []byte(moreSentinel), nil, 1)
+	post.Content, _ = addHeadingIDs(post.Content, site.Config)
+
+	errs := site.resolvePostLinks(post)
+	if len(errs) > 0 {
+		return errs
+	}
 
-	return site.Render(fd, "index.html", page)
+	return nil
 }
 
 // WriteTags generates tag documents.
 // These contain listings for all posts referencing a given tag.
 func WriteTags(site *Site) error {
-	dst := filepath.Join(site.Root, "deploy")
-	dst = filepath.Join(dst, "tags")
+	deploy := filepath.Join(site.Root, "deploy")
 
-	// Write individual posts.
+	jobs := make([]func() error, 0, len(site.Tags)+1)
+
+	// Write individual tags.
 	for _, tag := range site.Tags {
-		err := writeTag(dst, site, tag)
-		if err != nil {
-			return err
-		}
+		tag := tag
+		jobs = append(jobs, func() error {
+			return writeTag(deploy, site, tag)
+		})
 	}
 
 	// Write tag index.
-	return writeTagIndex(dst, site)
+	jobs = append(jobs, func() error {
+		return writeTagIndex(deploy, site)
+	})
+
+	return runJobs(site.Config.Jobs, jobs)
 }
 
 // writeTagIndex renders the tag index page.
-func writeTagIndex(path string, site *Site) error {
-	path = filepath.Join(path, "index.html")
-	page := NewTagIndexPage(site)
+func writeTagIndex(deploy string, site *Site) error {
+	path := OutputFile(deploy, "/tags/")
 
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePermission)
-	if err != nil {
-		return err
+	if !site.manifest.Stale(path, site.Inputs("tagindex.html", site.Posts...)) {
+		return nil
 	}
 
-	defer fd.Close()
-
-	return site.Render(fd, "tagindex.html", page)
+	page := NewTagIndexPage(site)
+	return renderPage(site, path, "tagindex.html", page)
 }
 
-// writeTag renders the given tag.
-func writeTag(path string, site *Site, tag Tag) error {
-	path = filepath.Join(path, string(tag))
+// writeTag renders the pages for the given tag.
+func writeTag(deploy string, site *Site, tag Tag) error {
+	url := site.Config.TagPath(tag)
+	pages := Paginate(recentPosts(site.FindPosts(tag), 0), site.Config.TagPageSize)
 
-	// Create directories where necessary.
-	err := os.MkdirAll(path, DirPermission)
-	if err != nil {
-		return err
-	}
+	for i, posts := range pages {
+		pager := NewPaginator(url, i+1, len(pages))
+		path := OutputFile(deploy, pager.URL(i+1))
 
-	path = filepath.Join(path, "index.html")
-	page := NewTagPage(tag, site)
+		if !site.manifest.Stale(path, pageInputs(site, "tag.html", pages, posts...)) {
+			continue
+		}
 
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePermission)
-	if err != nil {
-		return err
-	}
+		page := NewTagPage(tag, site, posts, pager)
 
-	defer fd.Close()
+		err := renderPage(site, path, "tag.html", page)
+		if err != nil {
+			return err
+		}
+	}
 
-	return site.Render(fd, "tag.html", page)
+	return nil
 }
 
 // WritePosts generates post documents.
 func WritePosts(site *Site) error {
-	dst := filepath.Join(site.Root, "deploy")
-	dst = filepath.Join(dst, "posts")
+	deploy := filepath.Join(site.Root, "deploy")
+
+	jobs := make([]func() error, 0, len(site.Posts)+1)
+
+	posts := recentPosts(site.Posts, 0)
+	related := site.RelatedPosts(site.Config.RelatedPosts)
 
 	// Write individual posts.
-	for _, post := range site.Posts {
-		tags := site.FindTags(post)
-		err := writePost(dst, site, post, tags)
+	for i, post := range posts {
+		page := NewPostPage(site, post, site.FindTags(post)...)
+		page.related = related[post]
+
+		// Posts are sorted most recent first.
+		if i+1 < len(posts) {
+			page.prev = posts[i+1]
+		}
+
+		if i > 0 {
+			page.next = posts[i-1]
+		}
+
+		post := post
+		jobs = append(jobs, func() error {
+			err := writePost(deploy, site, post, page)
+			if err != nil {
+				return newFileError(post.file, 0, "%v", err)
+			}
+			return nil
+		})
+	}
+
+	// Write post index.
+	jobs = append(jobs, func() error {
+		return writePostIndex(deploy, site)
+	})
+
+	return runJobs(site.Config.Jobs, jobs)
+}
+
+// writePostIndex renders the posts index pages.
+func writePostIndex(deploy string, site *Site) error {
+	pages := Paginate(recentPosts(site.Posts, 0), site.Config.PageSize)
+
+	for i, posts := range pages {
+		pager := NewPaginator("/posts/", i+1, len(pages))
+		path := OutputFile(deploy, pager.URL(i+1))
+
+		if !site.manifest.Stale(path, pageInputs(site, "postindex.html", pages, posts...)) {
+			continue
+		}
+
+		page := NewPostIndexPage(site, posts, pager)
+
+		err := renderPage(site, path, "postindex.html", page)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Write post index.
-	return writePostIndex(dst, site)
+	return nil
 }
 
-// writePostIndex renders the posts index page.
-func writePostIndex(path string, site *Site) error {
-	path = filepath.Join(path, "index.html")
-	page := NewPostIndexPage(site)
+// writePost renders the given post. The page's neighbouring and
+// related posts are part of its inputs, as the page links to them.
+func writePost(deploy string, site *Site, post *Post, page *PostPage) error {
+	path := OutputFile(deploy, post.Path)
+	name := post.Template("post.html")
 
-	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePermission)
-	if err != nil {
-		return err
+	inputs := site.Inputs(name, append([]*Post{post}, page.related...)...)
+	if page.prev != nil {
+		inputs["prev"] = page.prev.file
+		inputs[page.prev.file] = page.prev.hash
 	}
 
-	defer fd.Close()
+	if page.next != nil {
+		inputs["next"] = page.next.file
+		inputs[page.next.file] = page.next.hash
+	}
+
+	if !site.manifest.Stale(path, inputs) {
+		return nil
+	}
 
-	return site.Render(fd, "postindex.html", page)
+	return renderPage(site, path, name, page)
 }
 
-// writePost renders the given post.
-func writePost(path string, site *Site, post *Post, tags []Tag) error {
-	dir, file := post.SafePath()
-	path = filepath.Join(path, dir)
+// pageInputs returns the inputs for a single page out of the given
+// set of pages, rendered with the named template. The number of pages
+// is included, as it affects the links to other pages.
+func pageInputs(site *Site, name string, pages [][]*Post, posts ...*Post) Inputs {
+	in := site.Inputs(name, posts...)
+	in["pages"] = strconv.Itoa(len(pages))
+	return in
+}
 
-	// Create directories where necessary.
-	err := os.MkdirAll(path, DirPermission)
+// renderPage renders a page using the specified template and
+// writes it to the given file. Directories are created as needed.
+func renderPage(site *Site, path, name string, page interface{}) error {
+	err := os.MkdirAll(filepath.Dir(path), DirPermission)
 	if err != nil {
 		return err
 	}
 
-	path = filepath.Join(path, file)
-	page := NewPostPage(post, tags...)
-
 	fd, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, FilePermission)
 	if err != nil {
 		return err
@@ -159,7 +269,7 @@ func writePost(path string, site *Site, post *Post, tags []Tag) error {
 
 	defer fd.Close()
 
-	return site.Render(fd, "post.html", page)
+	return site.Render(fd, name, page)
 }
 
 // CopyStatic copies static content from source to target directories.
@@ -167,7 +277,11 @@ func CopyStatic(site *Site) error {
 	src := filepath.Join(site.Root, "static")
 	dst := filepath.Join(site.Root, "deploy")
 
-	return filepath.Walk(src, func(file string, stat os.FileInfo, err error) error {
+	var jobs []func() error
+
+	// Create directories right away, so that they exist
+	// before any of the files are copied into them.
+	err := filepath.Walk(src, func(file string, stat os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -178,21 +292,41 @@ func CopyStatic(site *Site) error {
 			return os.MkdirAll(dst, DirPermission)
 		}
 
-		fs, err := os.Open(file)
-		if err != nil {
-			return err
-		}
-
-		defer fs.Close()
+		jobs = append(jobs, func() error {
+			return copyFile(site, file, dst)
+		})
+		return nil
+	})
 
-		fd, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, FilePermission)
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return err
+	}
 
-		defer fd.Close()
+	return runJobs(site.Config.Jobs, jobs)
+}
 
-		_, err = io.Copy(fd, fs)
+// copyFile copies the given static file to dst, provided its
+// contents changed since the previous build.
+func copyFile(site *Site, src, dst string) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
 		return err
-	})
+	}
+
+	rel, _ := filepath.Rel(site.Root, src)
+	inputs := Inputs{
+		rel:        hashBytes(data),
+		"optimize": site.optimizer.Settings(),
+	}
+
+	if !site.manifest.Stale(dst, inputs) {
+		return nil
+	}
+
+	data, err = site.optimizer.Optimize(src, data)
+	if err != nil {
+		return newError("%s: %v", rel, err)
+	}
+
+	return ioutil.WriteFile(dst, data, FilePermission)
 }
@@ -0,0 +1,97 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrorList holds multiple errors.
+type ErrorList []error
+
+// Error returns all errors, each on its own line.
+func (e ErrorList) Error() string {
+	list := make([]string, len(e))
+	for i, err := range e {
+		list[i] = err.Error()
+	}
+	return strings.Join(list, "\n")
+}
+
+// appendError adds the given error to the list, unless it is nil.
+// Lists of errors are added one by one.
+func appendError(list ErrorList, err error) ErrorList {
+	switch te := err.(type) {
+	case nil:
+		return list
+	case ErrorList:
+		return append(list, te...)
+	}
+	return append(list, err)
+}
+
+// Sort orders errors in source files by file and line. Other
+// errors come first, in their original order.
+func (e ErrorList) Sort() {
+	sort.SliceStable(e, func(i, j int) bool {
+		a, aok := e[i].(*fileError)
+		b, bok := e[j].(*fileError)
+
+		switch {
+		case !aok || !bok:
+			return !aok && bok
+		case a.file != b.file:
+			return a.file < b.file
+		}
+
+		return a.line < b.line
+	})
+}
+
+// runJobs runs the given functions, using at most n concurrent workers.
+// All jobs are run, regardless of failures. Any errors are returned
+// as an ErrorList, in the order of the jobs that produced them.
+func runJobs(n int, jobs []func() error) error {
+	if n < 1 {
+		n = 1
+	}
+
+	errs := make([]error, len(jobs))
+	queue := make(chan int)
+
+	var wg sync.WaitGroup
+	wg.Add(n)
+
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+
+			for index := range queue {
+				errs[index] = jobs[index]()
+			}
+		}()
+	}
+
+	for index := range jobs {
+		queue <- index
+	}
+
+	close(queue)
+	wg.Wait()
+
+	var list ErrorList
+	for _, err := range errs {
+		if err != nil {
+			list = append(list, err)
+		}
+	}
+
+	if len(list) == 0 {
+		return nil
+	}
+
+	return list
+}
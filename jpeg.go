@@ -0,0 +1,819 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+)
+
+// errJPEG is returned for JPEG images which can not be recompressed.
+var errJPEG = errors.New("Unsupported or invalid JPEG image.")
+
+// optimizeJPEG losslessly recompresses the given JPEG image. Comments
+// and metadata segments are stripped, and the Huffman tables are replaced
+// by tables which are optimal for the image. The quantized image data
+// itself is not changed. Segments which affect the way the image is
+// displayed (JFIF, Exif, ICC profiles and Adobe color transforms) are kept.
+//
+// Only sequential images with Huffman coding are recompressed. Others,
+// like progressive images, are only stripped of metadata. The original
+// data is returned if it can not be parsed, or if the result is not
+// any smaller.
+func optimizeJPEG(data []byte) []byte {
+	out, err := recompressJPEG(data)
+	if err != nil {
+		out = stripJPEG(data)
+	}
+
+	if len(out) >= len(data) {
+		return data
+	}
+
+	return out
+}
+
+// stripJPEG strips comments and metadata segments from the given
+// JPEG image, without touching the image data itself.
+//
+// The original data is returned if it can not be parsed.
+func stripJPEG(data []byte) []byte {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return data
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xff {
+			return data
+		}
+
+		marker := data[i+1]
+
+		// Skip fill bytes.
+		if marker == 0xff {
+			i++
+			continue
+		}
+
+		// Start of scan: everything from here on is image data.
+		if marker == 0xda {
+			return append(out, data[i:]...)
+		}
+
+		end := i + 2 + (int(data[i+2])<<8 | int(data[i+3]))
+		if end > len(data) {
+			return data
+		}
+
+		if keepJPEGSegment(marker, data[i+4:end]) {
+			out = append(out, data[i:end]...)
+		}
+
+		i = end
+	}
+
+	return data
+}
+
+// keepJPEGSegment determines if the JPEG segment with the given marker
+// and payload should be kept.
+func keepJPEGSegment(marker byte, payload []byte) bool {
+	switch {
+	case marker == 0xfe: // Comment
+		return false
+	case marker == 0xe1: // Exif or XMP
+		return bytes.HasPrefix(payload, []byte("Exif\x00"))
+	case marker >= 0xe0 && marker <= 0xef:
+		return marker == 0xe0 || marker == 0xe2 || marker == 0xee
+	}
+	return true
+}
+
+// jpegComponent describes a color component of a JPEG frame.
+type jpegComponent struct {
+	id   byte // Component identifier.
+	h, v int  // Sampling factors.
+}
+
+// jpegFrame describes the dimensions and components of a JPEG image.
+type jpegFrame struct {
+	width, height int
+	hmax, vmax    int
+	components    []jpegComponent
+}
+
+// jpegSymbol is a Huffman coded symbol in the image data, along with
+// the additional bits which follow it.
+type jpegSymbol struct {
+	table uint8  // Index of the Huffman table: class * 4 + id.
+	value byte   // The coded value.
+	size  uint8  // Number of additional bits.
+	bits  uint16 // The additional bits.
+}
+
+// jpegHuffman is a Huffman table, as defined in a DHT segment.
+type jpegHuffman struct {
+	values  []byte    // Coded values, by increasing code length.
+	minCode [17]int32 // Smallest code of each length.
+	maxCode [17]int32 // Largest code of each length, or -1.
+	offset  [17]int   // Index into values of the first code of each length.
+}
+
+// newJPEGHuffman creates a Huffman table for decoding, from the given
+// code counts and values.
+func newJPEGHuffman(counts [16]byte, values []byte) (*jpegHuffman, error) {
+	t := new(jpegHuffman)
+	t.values = values
+
+	var code int32
+	var k int
+
+	for n := 1; n <= 16; n++ {
+		count := int(counts[n-1])
+
+		t.maxCode[n] = -1
+		if count > 0 {
+			t.offset[n] = k
+			t.minCode[n] = code
+			code += int32(count)
+			k += count
+			t.maxCode[n] = code - 1
+
+			if code > 1<<uint(n) {
+				return nil, errJPEG
+			}
+		}
+
+		code <<= 1
+	}
+
+	if k != len(values) {
+		return nil, errJPEG
+	}
+
+	return t, nil
+}
+
+// recompressJPEG decodes the Huffman coded data of the given sequential
+// JPEG image, and encodes it again with optimal Huffman tables. Each scan
+// is preceded by the tables it uses. Metadata is stripped as well.
+func recompressJPEG(data []byte) ([]byte, error) {
+	if len(data) < 2 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errJPEG
+	}
+
+	var frame *jpegFrame
+	var tables [8]*jpegHuffman
+	var interval int
+
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+
+	for i := 2; i+1 < len(data); {
+		if data[i] != 0xff {
+			return nil, errJPEG
+		}
+
+		marker := data[i+1]
+
+		// Skip fill bytes.
+		if marker == 0xff {
+			i++
+			continue
+		}
+
+		if marker == 0xd9 {
+			return append(out, 0xff, 0xd9), nil
+		}
+
+		if i+4 > len(data) {
+			return nil, errJPEG
+		}
+
+		end := i + 2 + (int(data[i+2])<<8 | int(data[i+3]))
+		if end > len(data) || end < i+4 {
+			return nil, errJPEG
+		}
+
+		payload := data[i+4 : end]
+
+		switch {
+		case marker == 0xc0 || marker == 0xc1: // Sequential, Huffman coded.
+			var err error
+			frame, err = parseJPEGFrame(payload)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, data[i:end]...)
+
+		case marker == 0xc4: // Huffman tables, replaced before each scan.
+			err := parseJPEGHuffman(payload, &tables)
+			if err != nil {
+				return nil, err
+			}
+
+		case marker == 0xcc: // Arithmetic coding conditioning.
+			return nil, errJPEG
+
+		case marker >= 0xc2 && marker <= 0xcf: // Other frame types.
+			return nil, errJPEG
+
+		case marker == 0xdd: // Restart interval.
+			if len(payload) != 2 {
+				return nil, errJPEG
+			}
+			interval = int(payload[0])<<8 | int(payload[1])
+			out = append(out, data[i:end]...)
+
+		case marker == 0xda: // Start of scan.
+			if frame == nil {
+				return nil, errJPEG
+			}
+
+			scan, n, err := parseJPEGScan(frame, &tables, interval, payload, data[end:])
+			if err != nil {
+				return nil, err
+			}
+
+			out, err = encodeJPEGScan(out, data[i:end], scan)
+			if err != nil {
+				return nil, err
+			}
+
+			end += n
+
+		default:
+			if keepJPEGSegment(marker, payload) {
+				out = append(out, data[i:end]...)
+			}
+		}
+
+		i = end
+	}
+
+	return nil, errJPEG
+}
+
+// parseJPEGFrame parses the payload of a SOF segment.
+func parseJPEGFrame(payload []byte) (*jpegFrame, error) {
+	if len(payload) < 6 {
+		return nil, errJPEG
+	}
+
+	f := new(jpegFrame)
+	f.height = int(payload[1])<<8 | int(payload[2])
+	f.width = int(payload[3])<<8 | int(payload[4])
+
+	n := int(payload[5])
+	if n == 0 || len(payload) != 6+3*n || f.width == 0 || f.height == 0 {
+		return nil, errJPEG
+	}
+
+	for i := 0; i < n; i++ {
+		c := payload[6+3*i:]
+		h, v := int(c[1]>>4), int(c[1]&15)
+		if h < 1 || h > 4 || v < 1 || v > 4 {
+			return nil, errJPEG
+		}
+
+		if h > f.hmax {
+			f.hmax = h
+		}
+
+		if v > f.vmax {
+			f.vmax = v
+		}
+
+		f.components = append(f.components, jpegComponent{c[0], h, v})
+	}
+
+	return f, nil
+}
+
+// parseJPEGHuffman parses the payload of a DHT segment into the given
+// set of tables.
+func parseJPEGHuffman(payload []byte, tables *[8]*jpegHuffman) error {
+	for len(payload) > 0 {
+		if len(payload) < 17 {
+			return errJPEG
+		}
+
+		class, id := payload[0]>>4, payload[0]&15
+		if class > 1 || id > 3 {
+			return errJPEG
+		}
+
+		var counts [16]byte
+		var total int
+
+		copy(counts[:], payload[1:17])
+		for _, n := range counts {
+			total += int(n)
+		}
+
+		if len(payload) < 17+total {
+			return errJPEG
+		}
+
+		t, err := newJPEGHuffman(counts, payload[17:17+total])
+		if err != nil {
+			return err
+		}
+
+		tables[class*4+id] = t
+		payload = payload[17+total:]
+	}
+
+	return nil
+}
+
+// jpegScan holds the image data of a scan, along with what is needed
+// to decode it.
+type jpegScan struct {
+	tables   [8]*jpegHuffman // Huffman tables in effect for the scan.
+	blocks   []jpegBlock     // Coding of each component in the scan.
+	mcus     int             // Number of MCUs in the scan.
+	interval int             // Number of MCUs between restart markers.
+	parts    [][]byte        // Image data, split at restart markers.
+}
+
+// jpegBlock describes the coding of the blocks of one component in a scan.
+type jpegBlock struct {
+	dc, ac uint8 // Indices of the Huffman tables.
+	count  int   // Number of blocks of this component in a MCU.
+}
+
+// parseJPEGScan parses a scan with the given SOS payload. Data holds the
+// image data following the SOS segment. It returns the scan and the
+// length of its image data.
+func parseJPEGScan(frame *jpegFrame, tables *[8]*jpegHuffman, interval int, payload, data []byte) (*jpegScan, int, error) {
+	if len(payload) < 1 {
+		return nil, 0, errJPEG
+	}
+
+	n := int(payload[0])
+	if n < 1 || n > 4 || len(payload) != 4+2*n {
+		return nil, 0, errJPEG
+	}
+
+	// Only sequential scans are supported.
+	ss, se, a := payload[1+2*n], payload[2+2*n], payload[3+2*n]
+	if ss != 0 || se != 63 || a != 0 {
+		return nil, 0, errJPEG
+	}
+
+	scan := new(jpegScan)
+	scan.tables = *tables
+	scan.blocks = make([]jpegBlock, n)
+
+	for i := range scan.blocks {
+		b := &scan.blocks[i]
+		id, sel := payload[1+2*i], payload[2+2*i]
+
+		var c *jpegComponent
+		for j := range frame.components {
+			if frame.components[j].id == id {
+				c = &frame.components[j]
+			}
+		}
+
+		if c == nil || sel>>4 > 3 || sel&15 > 3 {
+			return nil, 0, errJPEG
+		}
+
+		b.dc = sel >> 4
+		b.ac = 4 + sel&15
+
+		if tables[b.dc] == nil || tables[b.ac] == nil {
+			return nil, 0, errJPEG
+		}
+
+		// A scan of a single component is not interleaved. It holds
+		// one block per MCU, covering only the component's samples.
+		if n == 1 {
+			w := ceilDiv(ceilDiv(frame.width*c.h, frame.hmax), 8)
+			h := ceilDiv(ceilDiv(frame.height*c.v, frame.vmax), 8)
+			b.count = 1
+			scan.mcus = w * h
+			continue
+		}
+
+		b.count = c.h * c.v
+		scan.mcus = ceilDiv(frame.width, 8*frame.hmax) * ceilDiv(frame.height, 8*frame.vmax)
+	}
+
+	var length int
+	var err error
+
+	scan.parts, length, err = splitJPEGScan(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	scan.interval = interval
+	if interval == 0 || interval > scan.mcus {
+		scan.interval = scan.mcus
+	}
+
+	if len(scan.parts) != ceilDiv(scan.mcus, scan.interval) {
+		return nil, 0, errJPEG
+	}
+
+	return scan, length, nil
+}
+
+// splitJPEGScan finds the end of the image data of a scan. It returns
+// the data with byte stuffing removed, split at restart markers, along
+// with its length in the file.
+func splitJPEGScan(data []byte) ([][]byte, int, error) {
+	var parts [][]byte
+	var part []byte
+
+	for i := 0; i < len(data); i++ {
+		if data[i] != 0xff {
+			part = append(part, data[i])
+			continue
+		}
+
+		if i+1 >= len(data) {
+			break
+		}
+
+		switch m := data[i+1]; {
+		case m == 0x00:
+			part = append(part, 0xff)
+			i++
+		case m >= 0xd0 && m <= 0xd7:
+			parts = append(parts, part)
+			part = nil
+			i++
+		case m == 0xff:
+			// Fill byte before a marker.
+		default:
+			return append(parts, part), i, nil
+		}
+	}
+
+	return nil, 0, errJPEG
+}
+
+// decode decodes the image data of the scan. It calls fn for each symbol,
+// and restart for each restart marker.
+func (s *jpegScan) decode(fn func(jpegSymbol), restart func(int)) error {
+	for i, part := range s.parts {
+		if i > 0 {
+			restart(i - 1)
+		}
+
+		count := s.interval
+		if rest := s.mcus - i*s.interval; rest < count {
+			count = rest
+		}
+
+		r := &jpegBitReader{data: part}
+
+		for m := 0; m < count; m++ {
+			for _, b := range s.blocks {
+				for k := 0; k < b.count; k++ {
+					err := s.decodeBlock(r, b, fn)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// decodeBlock decodes the symbols of a single block of coefficients.
+func (s *jpegScan) decodeBlock(r *jpegBitReader, b jpegBlock, fn func(jpegSymbol)) error {
+	v, err := r.decode(s.tables[b.dc])
+	if err != nil {
+		return err
+	}
+
+	if v > 15 {
+		return errJPEG
+	}
+
+	bits, err := r.read(v)
+	if err != nil {
+		return err
+	}
+
+	fn(jpegSymbol{b.dc, v, v, bits})
+
+	for k := 1; k < 64; {
+		rs, err := r.decode(s.tables[b.ac])
+		if err != nil {
+			return err
+		}
+
+		run, size := rs>>4, rs&15
+
+		if size == 0 {
+			fn(jpegSymbol{b.ac, rs, 0, 0})
+
+			// Anything but a run of 16 zeroes ends the block.
+			if run != 15 {
+				break
+			}
+
+			k += 16
+			continue
+		}
+
+		k += int(run)
+		if k > 63 {
+			return errJPEG
+		}
+
+		bits, err := r.read(size)
+		if err != nil {
+			return err
+		}
+
+		fn(jpegSymbol{b.ac, rs, size, bits})
+		k++
+	}
+
+	return nil
+}
+
+// encodeJPEGScan appends the given scan to out, encoded with optimal
+// Huffman tables. The tables are defined in a DHT segment, followed by
+// the scan's SOS segment and its image data.
+//
+// The scan is decoded twice: once to count the symbols, and once
+// to encode them with the new tables.
+func encodeJPEGScan(out, sos []byte, scan *jpegScan) ([]byte, error) {
+	var freq [8][256]int64
+
+	err := scan.decode(func(sym jpegSymbol) {
+		freq[sym.table][sym.value]++
+	}, func(int) {})
+
+	if err != nil {
+		return nil, err
+	}
+
+	var dht []byte
+	var codes [8]*[256]uint16
+	var sizes [8]*[256]uint8
+
+	for t := range freq {
+		var used bool
+		for _, n := range freq[t] {
+			used = used || n > 0
+		}
+
+		if !used {
+			continue
+		}
+
+		counts, values := optimalJPEGHuffman(&freq[t])
+
+		dht = append(dht, byte(t/4<<4|t%4))
+		dht = append(dht, counts[:]...)
+		dht = append(dht, values...)
+
+		codes[t], sizes[t] = jpegCodes(counts, values)
+	}
+
+	out = append(out, 0xff, 0xc4, byte((len(dht)+2)>>8), byte(len(dht)+2))
+	out = append(out, dht...)
+	out = append(out, sos...)
+
+	w := &jpegBitWriter{out: out}
+
+	err = scan.decode(func(sym jpegSymbol) {
+		w.write(uint32(codes[sym.table][sym.value]), sizes[sym.table][sym.value])
+		w.write(uint32(sym.bits), sym.size)
+	}, func(i int) {
+		w.flush()
+		w.out = append(w.out, 0xff, 0xd0+byte(i%8))
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return w.flush(), nil
+}
+
+// optimalJPEGHuffman computes the optimal Huffman table for the given
+// symbol frequencies, with codes of at most 16 bits, none of which
+// consist of only 1 bits. This follows section K.2 of the JPEG standard.
+func optimalJPEGHuffman(freq *[256]int64) ([16]byte, []byte) {
+	var f [257]int64
+	var size [257]int
+	var next [257]int
+
+	copy(f[:], freq[:])
+
+	// Reserve a code, so that no code consists of only 1 bits.
+	f[256] = 1
+
+	for i := range next {
+		next[i] = -1
+	}
+
+	for {
+		// Find the two least frequent symbols, preferring the last one
+		// found for equal frequencies.
+		c1, c2 := -1, -1
+		for i := range f {
+			if f[i] > 0 && (c1 < 0 || f[i] <= f[c1]) {
+				c1 = i
+			}
+		}
+
+		for i := range f {
+			if f[i] > 0 && i != c1 && (c2 < 0 || f[i] <= f[c2]) {
+				c2 = i
+			}
+		}
+
+		if c2 < 0 {
+			break
+		}
+
+		f[c1] += f[c2]
+		f[c2] = 0
+
+		size[c1]++
+		for next[c1] >= 0 {
+			c1 = next[c1]
+			size[c1]++
+		}
+
+		next[c1] = c2
+
+		size[c2]++
+		for next[c2] >= 0 {
+			c2 = next[c2]
+			size[c2]++
+		}
+	}
+
+	var bits [33]int
+	for _, n := range size {
+		if n > 0 {
+			bits[n]++
+		}
+	}
+
+	// Limit code lengths to 16 bits.
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+
+	// Drop the reserved code, which is one of the longest.
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]--
+
+	var counts [16]byte
+	for i := range counts {
+		counts[i] = byte(bits[i+1])
+	}
+
+	var values []byte
+	for n := 1; n <= 32; n++ {
+		for v := 0; v < 256; v++ {
+			if size[v] == n {
+				values = append(values, byte(v))
+			}
+		}
+	}
+
+	return counts, values
+}
+
+// jpegCodes returns the code and code length for each value in the
+// Huffman table with the given code counts and values.
+func jpegCodes(counts [16]byte, values []byte) (*[256]uint16, *[256]uint8) {
+	codes := new([256]uint16)
+	sizes := new([256]uint8)
+
+	var code uint16
+	var k int
+
+	for n := 1; n <= 16; n++ {
+		for i := 0; i < int(counts[n-1]); i++ {
+			codes[values[k]] = code
+			sizes[values[k]] = uint8(n)
+			code++
+			k++
+		}
+		code <<= 1
+	}
+
+	return codes, sizes
+}
+
+// jpegBitReader reads bits from image data without byte stuffing.
+type jpegBitReader struct {
+	data []byte
+	pos  int    // Index of the next byte to buffer.
+	acc  uint64 // Buffered bits, starting at the most significant bit.
+	bits uint   // Number of buffered bits.
+}
+
+// fill buffers as many bytes as fit.
+func (r *jpegBitReader) fill() {
+	for r.bits <= 56 && r.pos < len(r.data) {
+		r.acc |= uint64(r.data[r.pos]) << (56 - r.bits)
+		r.bits += 8
+		r.pos++
+	}
+}
+
+// read reads the given number of bits, most significant bit first.
+func (r *jpegBitReader) read(n uint8) (uint16, error) {
+	if r.bits < uint(n) {
+		r.fill()
+		if r.bits < uint(n) {
+			return 0, errJPEG
+		}
+	}
+
+	v := uint16(r.acc >> (64 - n))
+	r.acc <<= n
+	r.bits -= uint(n)
+	return v, nil
+}
+
+// decode reads a single value coded with the given Huffman table.
+func (r *jpegBitReader) decode(t *jpegHuffman) (byte, error) {
+	if r.bits < 16 {
+		r.fill()
+	}
+
+	for n := uint(1); n <= 16 && n <= r.bits; n++ {
+		code := int32(r.acc >> (64 - n))
+
+		if t.maxCode[n] >= 0 && code <= t.maxCode[n] {
+			r.acc <<= n
+			r.bits -= n
+			return t.values[t.offset[n]+int(code-t.minCode[n])], nil
+		}
+	}
+
+	return 0, errJPEG
+}
+
+// jpegBitWriter appends bits to image data, with byte stuffing.
+type jpegBitWriter struct {
+	out  []byte
+	acc  uint32 // Pending bits.
+	bits uint   // Number of pending bits.
+}
+
+// write writes the n least significant bits of v.
+func (w *jpegBitWriter) write(v uint32, n uint8) {
+	w.acc = w.acc<<n | v&(1<<n-1)
+	w.bits += uint(n)
+
+	for w.bits >= 8 {
+		b := byte(w.acc >> (w.bits - 8))
+		w.out = append(w.out, b)
+		if b == 0xff {
+			w.out = append(w.out, 0x00)
+		}
+		w.bits -= 8
+	}
+}
+
+// flush pads the pending bits with 1 bits to a whole byte, and returns
+// the written data.
+func (w *jpegBitWriter) flush() []byte {
+	if w.bits > 0 {
+		n := uint8(8 - w.bits)
+		w.write(1<<n-1, n)
+	}
+	return w.out
+}
+
+// ceilDiv divides a by b, rounding up.
+func ceilDiv(a, b int) int {
+	return (a + b - 1) / b
+}
@@ -0,0 +1,135 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"html"
+	"html/template"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+var regLink = regexp.MustCompile(`(\s(?:href|src)=")([^"]*)(")`)
+
+// resolveLinks rewrites relative links to Markdown sources in the content
+// of all posts, so they point to the URLs generated for those sources.
+//
+// Links to sources which are not part of the site are reported as an
+// error, or as a warning, depending on the 'brokenlinks' setting.
+// Warnings are errors as well, in strict mode.
+func (s *Site) resolveLinks() error {
+	var errs ErrorList
+
+	for _, post := range s.Posts {
+		errs = append(errs, s.resolvePostLinks(post)...)
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// resolvePostLinks rewrites relative links to Markdown sources in the
+// content and summary of the given post. The new links include the path
+// of the site URL, if any. The linked posts are recorded, so that the
+// post is regenerated when their URLs change.
+func (s *Site) resolvePostLinks(post *Post) ErrorList {
+	var errs ErrorList
+
+	post.Content = regLink.ReplaceAllFunc(post.Content, func(attr []byte) []byte {
+		m := regLink.FindSubmatch(attr)
+
+		target, fragment, ok := s.linkTarget(post, string(m[2]))
+		if !ok {
+			return attr
+		}
+
+		if target == nil {
+			link := html.UnescapeString(string(m[2]))
+			err := newFileError(post.file, s.linkLine(post.file, link),
+				"Link to %s, which is not part of the site.", link)
+
+			if s.Config.BrokenLinks == "error" {
+				errs = append(errs, err)
+			} else {
+				errs = appendError(errs, s.report(err))
+			}
+			return attr
+		}
+
+		if !containsPost(post.links, target) {
+			post.links = append(post.links, target)
+		}
+
+		return []byte(string(m[1]) + template.HTMLEscapeString(s.Config.RelURL(target.Path)+fragment) + string(m[3]))
+	})
+
+	post.Summary = template.HTML(regLink.ReplaceAllStringFunc(string(post.Summary), func(attr string) string {
+		m := regLink.FindStringSubmatch(attr)
+
+		target, fragment, ok := s.linkTarget(post, m[2])
+		if !ok || target == nil {
+			return attr
+		}
+
+		return m[1] + template.HTMLEscapeString(s.Config.RelURL(target.Path)+fragment) + m[3]
+	}))
+
+	return errs
+}
+
+// linkTarget finds the post which the given, HTML-escaped link in the
+// given post refers to. It returns false if the link does not refer to
+// a Markdown source, relative to the post's own source. The returned
+// post is nil if the source is not part of the site.
+//
+// Any query string or fragment in the link is returned as well.
+func (s *Site) linkTarget(post *Post, link string) (*Post, string, bool) {
+	u, err := url.Parse(html.UnescapeString(link))
+	if err != nil || u.IsAbs() || len(u.Host) > 0 || len(u.Path) == 0 {
+		return nil, "", false
+	}
+
+	if strings.HasPrefix(u.Path, "/") || !strings.EqualFold(path.Ext(u.Path), ".md") {
+		return nil, "", false
+	}
+
+	var suffix string
+	if len(u.RawQuery) > 0 {
+		suffix += "?" + u.RawQuery
+	}
+	if len(u.Fragment) > 0 {
+		suffix += "#" + u.Fragment
+	}
+
+	file := path.Join(path.Dir(filepath.ToSlash(post.file)), u.Path)
+
+	// Posts which failed to load have been reported already.
+	if s.failed[file] {
+		return nil, "", false
+	}
+
+	for _, target := range s.Posts {
+		if filepath.ToSlash(target.file) == file {
+			return target, suffix, true
+		}
+	}
+
+	return nil, suffix, true
+}
+
+// containsPost returns true if the given post is in the list.
+func containsPost(list []*Post, post *Post) bool {
+	for _, p := range list {
+		if p == post {
+			return true
+		}
+	}
+	return false
+}
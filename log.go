@@ -24,10 +24,31 @@ func newError(msg string, argv ...interface{}) error {
 	return fmt.Errorf(msg, argv...)
 }
 
+// fileError is a problem found in a site source file.
+type fileError struct {
+	file string // Source file, relative to the site root.
+	line int    // Line number, starting at 1. Zero if unknown.
+	msg  string // Description of the problem.
+}
+
+// newFileError creates a new, formatted error for the given
+// line of the given file. The line may be zero if it is unknown.
+func newFileError(file string, line int, msg string, argv ...interface{}) error {
+	return &fileError{file, line, fmt.Sprintf(msg, argv...)}
+}
+
+// Error returns the error in the form 'file:line: message'.
+func (e *fileError) Error() string {
+	if e.line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+	}
+	return fmt.Sprintf("%s: %s", e.file, e.msg)
+}
+
 // check tests the given error value. If not nil, the error
 // is displayed and the program exits.
 func check(err error) {
 	if err != nil {
-		fatal("%v", err)
+		fatal("%v\n", err)
 	}
 }
@@ -10,43 +10,131 @@ import (
 )
 
 func main() {
-	path, debug := parseArgs()
+	path, debug, addr, checks := parseArgs()
 
-	path, err := ValidatePath(path)
+	if len(addr) > 0 {
+		err := Serve(path, addr)
+		check(err)
+		return
+	}
+
+	site, err := build(path, debug)
 	check(err)
 
-	site, err := LoadSite(path)
+	err = CheckSite(site, checks)
 	check(err)
+}
+
+// build generates the site at the given path. Only files whose inputs
+// changed since the previous build are regenerated, unless debug is set.
+func build(path string, debug bool) (*Site, error) {
+	path, err := ValidatePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, ok, err := LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start from scratch if we do not know what the previous
+	// build generated, or if we are asked to.
+	if debug || !ok {
+		manifest = NewManifest(path)
+
+		err = CleanDeploy(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+
+	err = overrideConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if debug {
+		config.DisableOptimizations()
+	}
+
+	site, err := LoadSite(path, config, manifest)
+	if err != nil {
+		return nil, err
+	}
 
 	err = WritePosts(site)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
 
 	err = WriteTags(site)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
+
+	err = WriteFeeds(site)
+	if err != nil {
+		return nil, err
+	}
+
+	err = WriteSitemap(site)
+	if err != nil {
+		return nil, err
+	}
 
 	err = WriteIndex(site)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
 
 	err = CopyStatic(site)
-	check(err)
+	if err != nil {
+		return nil, err
+	}
 
-	if debug {
-		return
+	err = WriteAssets(site)
+	if err != nil {
+		return nil, err
 	}
+
+	err = WriteHighlightCSS(site)
+	if err != nil {
+		return nil, err
+	}
+
+	err = manifest.Prune()
+	if err != nil {
+		return nil, err
+	}
+
+	return site, manifest.Save()
 }
 
 // parseArgs processes command line options and
 // returns the ones we are interested in.
-func parseArgs() (string, bool) {
+func parseArgs() (string, bool, string, LinkCheck) {
 	flag.Usage = usage
 
 	version := flag.Bool("version", false, "")
 	debug := flag.Bool("debug", false, "")
+	serve := flag.String("serve", "", "")
+	checkLinks := flag.Bool("check", false, "")
+	checkExternal := flag.Bool("checkexternal", false, "")
+
+	for _, name := range configFlags {
+		flag.String(name, "", "")
+	}
+
+	for _, name := range configBoolFlags {
+		flag.Bool(name, false, "")
+	}
 
-	flag.StringVar(&DefaultTags, "tags", "", "")
-	flag.StringVar(&DefaultKeywords, "keywords", "", "")
-	flag.StringVar(&DefaultLang, "lang", DefaultLang, "")
-	flag.StringVar(&DefaultDir, "dir", DefaultDir, "")
 	flag.Parse()
 
 	if *version {
@@ -62,13 +150,62 @@ func parseArgs() (string, bool) {
 		path = flag.Arg(0)
 	}
 
-	return path, *debug
+	checks := CheckNone
+	if *checkExternal {
+		checks = CheckExternal
+	} else if *checkLinks {
+		checks = CheckInternal
+	}
+
+	return path, *debug, *serve, checks
+}
+
+// configFlags lists the command line options which override
+// settings from the site configuration file.
+var configFlags = []string{
+	"title", "url", "copyright", "lang", "dir", "tags", "keywords", "feedlength",
+	"permalink", "tagpermalink", "theme", "pagesize", "tagpagesize", "indexpagesize",
+	"highlightstyle", "tocdepth", "summarylength", "related", "brokenlinks", "jobs",
+}
+
+// configBoolFlags lists the boolean command line options which override
+// settings from the site configuration file.
+var configBoolFlags = []string{
+	"drafts", "future", "expired", "linenos", "headinganchors", "strict",
+}
+
+// overrideConfig applies configuration settings which were
+// explicitly specified on the command line.
+func overrideConfig(config *Config) error {
+	var err error
+
+	flag.Visit(func(f *flag.Flag) {
+		if err != nil {
+			return
+		}
+
+		for _, name := range append(configFlags, configBoolFlags...) {
+			if name == f.Name {
+				err = config.Set(f.Name, f.Value.String())
+				return
+			}
+		}
+	})
+
+	return err
 }
 
 // usage prints usage information.
 func usage() {
+	config := NewConfig()
+
 	fmt.Printf(`usage: %v [options] [<path>]
 
+Site settings are read from the '%s' file in the site root, if it exists.
+Its global section accepts the same keys as the output options listed below.
+A [params] section can hold arbitrary values for use in templates. Options
+specified on the command line override the settings from this file.
+
 [output options]
   -lang=%s
     Default ISO language code to use. This can be overridden on a per-document
@@ -86,13 +223,130 @@ func usage() {
     Default, comma-separated list of keywords to use. This can be overridden on
     a per-document basis with the 'keywords' metadata key.
 
+  -title=<title>
+    Site title to use in templates and generated Atom and RSS feeds.
+
+  -copyright=<text>
+    Copyright notice to use in templates.
+
+  -url=%s
+    Base URL of the site. This is used to construct the absolute links
+    in generated Atom and RSS feeds.
+
+  -feedlength=%d
+    Maximum number of posts to include in a single feed. A value of 0
+    includes all posts.
+
+  -drafts
+    Include posts which are marked as draft through the 'draft' metadata key.
+
+  -future
+    Include posts with a 'postdate' in the future.
+
+  -expired
+    Include posts whose 'expires' date has passed.
+
+  -permalink=%s
+    URL pattern for posts. It can hold the variables :year, :month, :day,
+    :slug and :section. The latter is the first directory below 'posts',
+    holding the post source. Patterns ending in a slash produce directory
+    style URLs, served by index.html files. E.g.: /:year/:month/:slug/
+
+  -tagpermalink=%s
+    URL pattern for tag pages. It can hold the :tag variable.
+
+  -theme=<name>
+    Name of a theme in the site's 'themes' directory. Templates from
+    themes/<name>/templates are used for every template the site does not
+    define in its own 'templates' directory.
+
+  -pagesize=<n>
+  -tagpagesize=<n>
+  -indexpagesize=<n>
+    Maximum number of posts listed on a single page of the post index, the
+    tag pages and the front page respectively. Longer listings are split
+    over multiple pages, served at <url>/page/<n>/. A value of 0 lists all
+    posts on a single page. This is the default.
+
+  -highlightstyle=%s
+    Style used for syntax highlighting of fenced code blocks which name their
+    language. Colours are defined in a generated stylesheet, which templates
+    should link to: %s. An empty value disables highlighting.
+
+  -linenos
+    Show line numbers in highlighted code blocks. Individual blocks can
+    override this and select lines to highlight on their opening line:
+    ~~~go linenos=false linenostart=10 hl_lines=2-4,7
+
+  -headinganchors
+    Add a permalink anchor to every heading. Headings always receive an ID,
+    derived from their text, so they can be linked to.
+
+  -tocdepth=%d
+    Deepest heading level to include in a post's table of contents, which
+    templates can render through {{.TOC}}. A value of 0 disables it. Posts
+    can opt out with the 'toc' metadata key.
+
+  -summarylength=%d
+    Number of words in a post's summary, when its content holds no
+    <!--more--> marker. Summaries are shown in listings and feeds.
+
+  -related=%d
+    Maximum number of related posts listed on a post page. Posts are
+    related if they share tags. Those sharing the most tags come first,
+    then those with the most similar content. A value of 0 disables this.
+
+  -brokenlinks=%s
+    Relative links to the Markdown source of another post are changed to
+    point to that post's URL. This determines whether links to sources
+    which are not part of the site 'warn' or are an 'error'.
+
+  -strict
+    Treat warnings as errors. These include tags without a usable name, and
+    links to Markdown sources which are not part of the site.
+
+  -jobs=%d
+    Number of posts, tags and static files to process concurrently.
+
   -debug
     Generates output in debug mode. This means that the entire site will
-    be regenerated, without compression of HTML, JS, CSS and PNG images.
+    be regenerated, without optimizing any of the generated files.
+    Without it, only files whose sources, templates or settings changed
+    since the previous build are regenerated. Files whose sources no
+    longer exist are deleted.
+    Outside debug mode, generated HTML and static CSS, JS and SVG files
+    are minified, and PNG and JPEG images are losslessly recompressed.
+    JPEG images get Huffman tables optimized for their image data, and
+    are stripped of comments and metadata.
+    Each of these can be turned off in the configuration file through the
+    'minifyhtml', 'minifycss', 'minifyjs', 'minifysvg', 'optimizepng' and
+    'optimizejpeg' keys.
 
 [misc options]
+  -check
+    Checks the generated site for broken links, after building it. Every
+    link to a page or file of the site must point to a generated file, and
+    links with a fragment to an element with that ID. Broken links are
+    reported by the post they appear in, and make the program exit with
+    a non-zero status.
+
+  -checkexternal
+    Like -check, but also requests every link to other sites. Requests run
+    concurrently, with at most one request per second to any one host.
+    Links found to work are recorded in the '%s' file and are not checked
+    again for a week.
+
+  -serve=<address>
+    Builds the site and serves it over HTTP on the given address.
+    E.g.: -serve=localhost:8080. The site is rebuilt whenever its sources
+    change, after which open browser pages are reloaded. Build errors are
+    shown in the browser instead of terminating the program.
+
   -version
     Displays version information.
 `,
-		os.Args[0], DefaultLang, DefaultDir)
+		os.Args[0], ConfigFile, config.Lang, config.Dir, config.URL, config.FeedLength,
+		config.Permalink, config.TagPermalink, config.HighlightStyle,
+		HighlightFile, config.TOCDepth, config.SummaryLength, config.RelatedPosts, config.BrokenLinks, config.Jobs,
+		LinkCacheFile)
 }
@@ -0,0 +1,154 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// ManifestFile is the name of the build manifest, relative to the site root.
+const ManifestFile = ".buildcache"
+
+// Inputs maps the names of the inputs for a generated file onto
+// hashes of their contents.
+type Inputs map[string]string
+
+// Manifest records the inputs of every file generated by a build.
+// It is used to regenerate only those files whose inputs have
+// changed since the previous build.
+type Manifest struct {
+	Outputs map[string]Inputs // Inputs, keyed by output path.
+	deploy  string            // Deploy directory the outputs are relative to.
+	file    string            // Path to the manifest file.
+	used    map[string]bool   // Outputs generated by the current build.
+	lock    sync.Mutex        // Guards Outputs and used.
+}
+
+// NewManifest creates a new, empty manifest for the site at the given root.
+func NewManifest(root string) *Manifest {
+	m := new(Manifest)
+	m.Outputs = make(map[string]Inputs)
+	m.deploy = filepath.Join(root, "deploy")
+	m.file = filepath.Join(root, ManifestFile)
+	m.used = make(map[string]bool)
+	return m
+}
+
+// LoadManifest loads the build manifest for the site at the given root.
+// It returns false if there was no existing manifest.
+func LoadManifest(root string) (*Manifest, bool, error) {
+	m := NewManifest(root)
+
+	data, err := ioutil.ReadFile(m.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return m, false, nil
+		}
+		return nil, false, err
+	}
+
+	err = json.Unmarshal(data, &m.Outputs)
+	if err != nil {
+		return nil, false, newError("%s: %v", m.file, err)
+	}
+
+	if m.Outputs == nil {
+		m.Outputs = make(map[string]Inputs)
+	}
+
+	return m, true, nil
+}
+
+// Stale determines if the given output file needs to be regenerated.
+// This is the case if it does not exist, or if its inputs differ from
+// those recorded in the previous build. The given inputs are recorded
+// as the file's new inputs.
+//
+// The path is expected to be located in the deploy directory.
+func (m *Manifest) Stale(path string, inputs Inputs) bool {
+	rel, err := filepath.Rel(m.deploy, path)
+	if err != nil {
+		return true
+	}
+
+	rel = filepath.ToSlash(rel)
+
+	m.lock.Lock()
+	old, ok := m.Outputs[rel]
+	m.Outputs[rel] = inputs
+	m.used[rel] = true
+	m.lock.Unlock()
+
+	if !ok || !inputs.Equal(old) {
+		return true
+	}
+
+	_, err = os.Stat(path)
+	return err != nil
+}
+
+// Prune deletes all files recorded in the previous build, which have
+// not been generated by the current one. Directories left empty by
+// this are removed as well.
+func (m *Manifest) Prune() error {
+	for rel := range m.Outputs {
+		if m.used[rel] {
+			continue
+		}
+
+		delete(m.Outputs, rel)
+
+		path := filepath.Join(m.deploy, filepath.FromSlash(rel))
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		// Remove parent directories, for as long as they are empty.
+		for dir := filepath.Dir(path); dir != m.deploy; dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Save writes the manifest to disk.
+func (m *Manifest) Save() error {
+	data, err := json.MarshalIndent(m.Outputs, "", " ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(m.file, data, FilePermission)
+}
+
+// Equal returns true if both sets of inputs are the same.
+func (in Inputs) Equal(other Inputs) bool {
+	if len(in) != len(other) {
+		return false
+	}
+
+	for name, hash := range in {
+		if v, ok := other[name]; !ok || v != hash {
+			return false
+		}
+	}
+
+	return true
+}
+
+// hashBytes returns a hash of the given data.
+func hashBytes(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
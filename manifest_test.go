@@ -0,0 +1,106 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestStale(t *testing.T) {
+	root := t.TempDir()
+	deploy := filepath.Join(root, "deploy")
+
+	// Outputs of the previous build.
+	files := map[string]Inputs{
+		"index.html":                {"templates": "a", "posts": "1"},
+		"posts/a.html":              {"templates": "a", "post": "2"},
+		"posts/b.html":              {"templates": "a", "post": "3"},
+		"tags/go/index.html":        {"templates": "b", "posts": "4"},
+		"tags/go/page/2/index.html": {"templates": "b", "posts": "5"},
+	}
+
+	m, ok, err := LoadManifest(root)
+	if err != nil || ok {
+		t.Fatalf("Loading a missing manifest: have %v, %v", ok, err)
+	}
+
+	for rel, inputs := range files {
+		path := filepath.Join(deploy, filepath.FromSlash(rel))
+
+		if !m.Stale(path, inputs) {
+			t.Errorf("%s is not stale in the first build.", rel)
+		}
+
+		err = os.MkdirAll(filepath.Dir(path), DirPermission)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(rel), FilePermission)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	m, ok, err = LoadManifest(root)
+	if err != nil || !ok {
+		t.Fatalf("Loading the manifest: have %v, %v", ok, err)
+	}
+
+	// posts/b.html is gone from the deploy directory, and the tag
+	// listing shrank to a single page.
+	if err = os.Remove(filepath.Join(deploy, "posts", "b.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rel    string
+		inputs Inputs
+		want   bool
+	}{
+		{"index.html", Inputs{"templates": "a", "posts": "1"}, false},
+		{"posts/a.html", Inputs{"templates": "a", "post": "2", "site": "6"}, true},
+		{"posts/b.html", Inputs{"templates": "a", "post": "3"}, true},
+		{"tags/go/index.html", Inputs{"templates": "c", "posts": "4"}, true},
+		{"posts/c.html", Inputs{"templates": "a", "post": "7"}, true},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(deploy, filepath.FromSlash(test.rel))
+		if got := m.Stale(path, test.inputs); got != test.want {
+			t.Errorf("%s: have stale %v, want %v", test.rel, got, test.want)
+		}
+	}
+
+	if err = m.Prune(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Files not generated by the current build are removed, along
+	// with the directories this leaves empty.
+	for _, name := range []string{"tags/go/page/2/index.html", "tags/go/page"} {
+		if _, err := os.Stat(filepath.Join(deploy, filepath.FromSlash(name))); !os.IsNotExist(err) {
+			t.Errorf("%s was not pruned.", name)
+		}
+	}
+
+	for _, name := range []string{"index.html", "posts/a.html", "tags/go/index.html"} {
+		if _, err := os.Stat(filepath.Join(deploy, filepath.FromSlash(name))); err != nil {
+			t.Errorf("%s was pruned: %v", name, err)
+		}
+	}
+
+	if _, ok := m.Outputs["tags/go/page/2/index.html"]; ok {
+		t.Error("Pruned file is still recorded in the manifest.")
+	}
+
+	if len(m.Outputs) != len(tests) {
+		t.Errorf("Manifest records %d outputs, want %d.", len(m.Outputs), len(tests))
+	}
+}
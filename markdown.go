@@ -0,0 +1,123 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jteeuwen/blackfriday"
+)
+
+const (
+	// markdownFlags defines the HTML rendering options for post content.
+	markdownFlags = blackfriday.HTML_USE_XHTML |
+		blackfriday.HTML_USE_SMARTYPANTS |
+		blackfriday.HTML_SMARTYPANTS_FRACTIONS |
+		blackfriday.HTML_SMARTYPANTS_LATEX_DASHES
+
+	// markdownExtensions defines the Markdown extensions for post content.
+	markdownExtensions = blackfriday.EXTENSION_NO_INTRA_EMPHASIS |
+		blackfriday.EXTENSION_TABLES |
+		blackfriday.EXTENSION_FENCED_CODE |
+		blackfriday.EXTENSION_AUTOLINK |
+		blackfriday.EXTENSION_STRIKETHROUGH |
+		blackfriday.EXTENSION_SPACE_HEADERS
+)
+
+// htmlRenderer renders Markdown as HTML, with syntax highlighting
+// for fenced code blocks.
+type htmlRenderer struct {
+	blackfriday.Renderer
+	site *Site
+	file string    // Source file, for error reporting.
+	errs ErrorList // Errors encountered while rendering.
+}
+
+// BlockCode renders a code block. Blocks which name a known language
+// are highlighted. Others are rendered as-is.
+//
+// Unknown or invalid options in the info string are reported, and
+// do not stop the block from being rendered.
+func (r *htmlRenderer) BlockCode(out *bytes.Buffer, text []byte, info string) {
+	h := r.site.highlighter
+	if h == nil {
+		r.Renderer.BlockCode(out, text, info)
+		return
+	}
+
+	opt, problems := h.parseInfo(info)
+	if len(problems) > 0 {
+		line := r.site.findLine(r.file, func(line string) bool {
+			return isFence(line, info)
+		})
+
+		for _, msg := range problems {
+			r.errs = appendError(r.errs, r.site.report(newFileError(r.file, line, "%s", msg)))
+		}
+	}
+
+	var buf bytes.Buffer
+
+	ok, err := h.Highlight(&buf, text, opt)
+	if err != nil {
+		r.errs = append(r.errs, newFileError(r.file, 0, "%v", err))
+	}
+
+	if !ok {
+		r.Renderer.BlockCode(out, text, opt.lang)
+		return
+	}
+
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+
+	out.Write(buf.Bytes())
+}
+
+// BlockHtml renders a block of raw HTML. A MoreMarker on its own is
+// replaced with moreSentinel, which marks the end of the post summary
+// in the rendered content.
+func (r *htmlRenderer) BlockHtml(out *bytes.Buffer, text []byte) {
+	if string(bytes.TrimSpace(text)) != MoreMarker {
+		r.Renderer.BlockHtml(out, text)
+		return
+	}
+
+	if out.Len() > 0 {
+		out.WriteByte('\n')
+	}
+
+	out.WriteString(moreSentinel)
+	out.WriteByte('\n')
+}
+
+// isFence determines if the given line opens a fenced code
+// block with the given info string.
+func isFence(line, info string) bool {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "```") && !strings.HasPrefix(line, "~~~") {
+		return false
+	}
+
+	return strings.TrimSpace(strings.TrimLeft(line, "`~")) == strings.TrimSpace(info)
+}
+
+// Markdown renders the given Markdown document from the given
+// source file as HTML.
+func (s *Site) Markdown(data []byte, file string) ([]byte, error) {
+	r := &htmlRenderer{
+		Renderer: blackfriday.HtmlRenderer(markdownFlags, "", ""),
+		site:     s,
+		file:     file,
+	}
+
+	out := blackfriday.Markdown(data, r, markdownExtensions)
+	if len(r.errs) > 0 {
+		return out, r.errs
+	}
+
+	return out, nil
+}
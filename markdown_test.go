@@ -0,0 +1,66 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testSite creates a site with default settings and highlighting
+// enabled, without loading anything from disk.
+func testSite(t *testing.T) *Site {
+	s := new(Site)
+	s.Root = t.TempDir()
+	s.Config = NewConfig()
+
+	var err error
+	s.highlighter, err = NewHighlighter(s.Config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func TestMarkdownCodeBlockInfo(t *testing.T) {
+	s := testSite(t)
+	src := "```go linenos hl_lines=2\npackage main\n\nfunc main() {}\n```\n"
+
+	out, err := s.Markdown([]byte(src), "code.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html := string(out)
+
+	// Line numbers and highlighted lines are only rendered if the
+	// options following the language reach the renderer.
+	for _, want := range []string{`class="chroma"`, `class="lnt"`, `class="hl"`} {
+		if !strings.Contains(html, want) {
+			t.Errorf("Output does not contain %s:\n%s", want, html)
+		}
+	}
+}
+
+func TestMarkdownUnknownCodeBlockOption(t *testing.T) {
+	s := testSite(t)
+	src := "```go title=main.go\npackage main\n```\n"
+
+	out, err := s.Markdown([]byte(src), "code.md")
+	if err != nil {
+		t.Fatalf("Unknown option stopped the build: %v", err)
+	}
+
+	if !strings.Contains(string(out), `class="chroma"`) {
+		t.Errorf("Code block was not highlighted:\n%s", out)
+	}
+
+	s.Config.Strict = true
+
+	_, err = s.Markdown([]byte(src), "code.md")
+	if err == nil {
+		t.Fatal("Unknown option was not reported in strict mode.")
+	}
+}
@@ -0,0 +1,294 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/jteeuwen/ini"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	yamlMarker   = []byte("---")
+	tomlMarker   = []byte("+++")
+	regMetaKey   = regexp.MustCompile(`^([a-zA-Z0-9_-]+)\s*[:=]`)
+	regErrorLine = regexp.MustCompile(`(?i)\bline (\d+)`)
+)
+
+// metadata holds post meta data, independent of the format
+// it was written in.
+type metadata map[string]interface{}
+
+// S returns the value for the given key as a string. Lists are
+// returned as a comma-separated string. Returns defval if the key
+// does not exist.
+func (m metadata) S(key, defval string) string {
+	v, ok := m[key]
+	if !ok {
+		return defval
+	}
+
+	switch tv := v.(type) {
+	case string:
+		return tv
+	case []interface{}:
+		list := make([]string, 0, len(tv))
+		for _, item := range tv {
+			list = append(list, fmt.Sprint(item))
+		}
+		return strings.Join(list, ", ")
+	case time.Time:
+		return tv.Format(TimeFormat)
+	case nil:
+		return ""
+	}
+
+	return fmt.Sprint(v)
+}
+
+// B returns the value for the given key as a boolean.
+// Returns defval if the key does not exist.
+func (m metadata) B(key string, defval bool) (bool, error) {
+	v, ok := m[key]
+	if !ok {
+		return defval, nil
+	}
+
+	if b, ok := v.(bool); ok {
+		return b, nil
+	}
+
+	return strconv.ParseBool(m.S(key, ""))
+}
+
+// T returns the value for the given key as a timestamp.
+// Strings are expected to be in the TimeFormat layout, or in the
+// DayFormat layout for dates without a time.
+// Returns defval if the key does not exist.
+func (m metadata) T(key string, defval time.Time) (time.Time, error) {
+	v, ok := m[key]
+	if !ok {
+		return defval, nil
+	}
+
+	if t, ok := v.(time.Time); ok {
+		return t, nil
+	}
+
+	value := strings.TrimSpace(m.S(key, ""))
+
+	t, err := time.Parse(TimeFormat, value)
+	if err != nil {
+		if day, derr := time.Parse(DayFormat, value); derr == nil {
+			return day, nil
+		}
+	}
+
+	return t, err
+}
+
+// readMetadata finds and parses the meta data block in the given data.
+// The format is determined by the delimiters found. It returns the
+// meta data and any remaining data. If there is no meta data block,
+// the returned metadata is nil.
+func readMetadata(data []byte) (metadata, []byte, error) {
+	if block, rest, ok := splitFrontMatter(data, yamlMarker); ok {
+		meta, err := readYAML(block)
+		return meta, rest, err
+	}
+
+	if block, rest, ok := splitFrontMatter(data, tomlMarker); ok {
+		meta, err := readTOML(block)
+		return meta, rest, err
+	}
+
+	index := bytes.Index(data, endMeta)
+	if index == -1 {
+		return nil, data, nil
+	}
+
+	meta, err := readINI(data[:index])
+	return meta, data[index+len(endMeta):], err
+}
+
+// metaLines finds the line numbers of the top level keys in the meta
+// data block at the start of data, by their lower case names. It also
+// returns the number of lines preceding the block contents.
+func metaLines(data []byte) (map[string]int, int) {
+	lines := make(map[string]int)
+
+	marker, rest := splitLine(data)
+	offset := 1
+
+	if !bytes.Equal(marker, yamlMarker) && !bytes.Equal(marker, tomlMarker) {
+		marker = nil
+		offset = 0
+		rest = data
+	}
+
+	for n := offset + 1; len(rest) > 0; n++ {
+		var line []byte
+		line, rest = splitLine(rest)
+
+		// Stop at the end of the block, or at the first section
+		// or table, as the keys which follow are not top level.
+		if (marker != nil && bytes.Equal(line, marker)) ||
+			(marker == nil && bytes.Contains(line, endMeta)) ||
+			bytes.HasPrefix(line, []byte("[")) {
+			break
+		}
+
+		m := regMetaKey.FindSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		key := strings.ToLower(string(m[1]))
+		if _, ok := lines[key]; !ok {
+			lines[key] = n
+		}
+	}
+
+	return lines, offset
+}
+
+// errorLine returns the line number mentioned in an error from one of
+// the meta data parsers, after adding the given offset to it, along with
+// the error message, changed to mention the same line. The line is 0 if
+// the error does not mention one.
+func errorLine(err error, offset int) (int, string) {
+	msg := err.Error()
+
+	m := regErrorLine.FindStringSubmatchIndex(msg)
+	if m == nil {
+		return 0, msg
+	}
+
+	line, _ := strconv.Atoi(msg[m[2]:m[3]])
+	line += offset
+
+	return line, msg[:m[2]] + strconv.Itoa(line) + msg[m[3]:]
+}
+
+// splitFrontMatter checks if data starts with a front matter block,
+// delimited by lines holding only the given marker. If so, it returns
+// the block contents and any data following the closing delimiter.
+func splitFrontMatter(data, marker []byte) ([]byte, []byte, bool) {
+	line, rest := splitLine(data)
+	if !bytes.Equal(line, marker) {
+		return nil, nil, false
+	}
+
+	block := rest
+
+	for len(rest) > 0 {
+		var line []byte
+		start := len(block) - len(rest)
+		line, rest = splitLine(rest)
+
+		if bytes.Equal(line, marker) {
+			return block[:start], rest, true
+		}
+	}
+
+	return nil, nil, false
+}
+
+// splitLine returns the first line in data, without its line ending,
+// and whatever follows it.
+func splitLine(data []byte) ([]byte, []byte) {
+	var line []byte
+
+	index := bytes.IndexByte(data, '\n')
+	if index == -1 {
+		line, data = data, nil
+	} else {
+		line, data = data[:index], data[index+1:]
+	}
+
+	return bytes.TrimRight(line, " \t\r"), data
+}
+
+// readINI parses an INI meta data block.
+func readINI(data []byte) (metadata, error) {
+	ini := ini.New()
+	err := ini.LoadBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(metadata)
+	for key, value := range ini.Section("") {
+		meta[strings.ToLower(key)] = value
+	}
+
+	return meta, nil
+}
+
+// readYAML parses a YAML meta data block.
+func readYAML(data []byte) (metadata, error) {
+	var tmp map[string]interface{}
+
+	err := yaml.Unmarshal(data, &tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMetadata(tmp), nil
+}
+
+// readTOML parses a TOML meta data block.
+func readTOML(data []byte) (metadata, error) {
+	var tmp map[string]interface{}
+
+	_, err := toml.Decode(string(data), &tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMetadata(tmp), nil
+}
+
+// newMetadata creates metadata from the given map, using
+// lower case key names.
+func newMetadata(m map[string]interface{}) metadata {
+	meta := make(metadata, len(m))
+	for key, value := range m {
+		meta[strings.ToLower(key)] = value
+	}
+	return meta
+}
+
+// normalizeValue converts nested YAML maps, which have interface{} keys,
+// into maps with string keys. This makes them accessible to templates.
+func normalizeValue(v interface{}) interface{} {
+	switch tv := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(tv))
+		for key, value := range tv {
+			m[fmt.Sprint(key)] = normalizeValue(value)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(tv))
+		for key, value := range tv {
+			m[key] = normalizeValue(value)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(tv))
+		for i, value := range tv {
+			list[i] = normalizeValue(value)
+		}
+		return list
+	}
+	return v
+}
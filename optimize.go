@@ -0,0 +1,180 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+	"path/filepath"
+	"strings"
+
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/html"
+	"github.com/tdewolff/minify/v2/js"
+	"github.com/tdewolff/minify/v2/svg"
+)
+
+// Optimizer reduces the size of generated and copied files.
+// Which optimizations are applied is determined by the site configuration.
+//
+// A nil Optimizer leaves all data unchanged.
+type Optimizer struct {
+	config *Config
+	m      *minify.M
+}
+
+// NewOptimizer creates a new optimizer for the given configuration.
+func NewOptimizer(config *Config) *Optimizer {
+	o := new(Optimizer)
+	o.config = config
+	o.m = minify.New()
+	o.m.AddFunc("text/html", html.Minify)
+	o.m.AddFunc("text/css", css.Minify)
+	o.m.AddFunc("application/javascript", js.Minify)
+	o.m.AddFunc("image/svg+xml", svg.Minify)
+	return o
+}
+
+// Optimize optimizes the given file data, if an optimization for the
+// file's type is enabled. The type is determined by the file extension.
+func (o *Optimizer) Optimize(file string, data []byte) ([]byte, error) {
+	if o == nil {
+		return data, nil
+	}
+
+	c := o.config
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".html", ".htm":
+		if c.MinifyHTML {
+			return o.m.Bytes("text/html", data)
+		}
+	case ".css":
+		if c.MinifyCSS {
+			return o.m.Bytes("text/css", data)
+		}
+	case ".js":
+		if c.MinifyJS {
+			return o.m.Bytes("application/javascript", data)
+		}
+	case ".svg":
+		if c.MinifySVG {
+			return o.m.Bytes("image/svg+xml", data)
+		}
+	case ".png":
+		if c.OptimizePNG {
+			return optimizePNG(data), nil
+		}
+	case ".jpg", ".jpeg":
+		if c.OptimizeJPEG {
+			return optimizeJPEG(data), nil
+		}
+	}
+
+	return data, nil
+}
+
+// Settings returns a description of the enabled optimizations.
+// It is used to detect changes in optimization settings between builds.
+func (o *Optimizer) Settings() string {
+	if o == nil {
+		return ""
+	}
+
+	c := o.config
+	return strings.Join([]string{
+		flagString("html", c.MinifyHTML),
+		flagString("css", c.MinifyCSS),
+		flagString("js", c.MinifyJS),
+		flagString("svg", c.MinifySVG),
+		flagString("png", c.OptimizePNG),
+		flagString("jpeg", c.OptimizeJPEG),
+	}, "")
+}
+
+// flagString returns name if set is true. An empty string otherwise.
+func flagString(name string, set bool) string {
+	if set {
+		return name
+	}
+	return ""
+}
+
+// optimizePNG re-encodes the given PNG image at the best compression
+// level. The pixel data is not changed, and chunks which affect the way
+// the image is displayed (gamma, chromaticities, color profiles and pixel
+// dimensions) are copied. Animated images are left as they are, as only
+// their first frame would be kept.
+//
+// The original data is returned if it can not be decoded, or if
+// re-encoding does not make it any smaller.
+func optimizePNG(data []byte) []byte {
+	keep, ok := pngChunks(data)
+	if !ok {
+		return data
+	}
+
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		return data
+	}
+
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestCompression}
+
+	err = enc.Encode(&buf, img)
+	if err != nil {
+		return data
+	}
+
+	// The encoder writes the signature and IHDR chunk first. The kept
+	// chunks must precede the palette and image data, which follow it.
+	out := buf.Bytes()
+	if len(keep) > 0 {
+		const header = 8 + 12 + 13
+		out = append(append(append([]byte{}, out[:header]...), keep...), out[header:]...)
+	}
+
+	if len(out) >= len(data) {
+		return data
+	}
+
+	return out
+}
+
+// pngChunks returns the chunks of the given PNG image which must be
+// kept when it is re-encoded. It returns false if the image can not be
+// re-encoded at all, because it is animated or can not be parsed.
+func pngChunks(data []byte) ([]byte, bool) {
+	const signature = "\x89PNG\r\n\x1a\n"
+
+	if !bytes.HasPrefix(data, []byte(signature)) {
+		return nil, false
+	}
+
+	var keep []byte
+
+	for i := len(signature); i+8 <= len(data); {
+		size := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + size
+		if size < 0 || end > len(data) || end < i {
+			return nil, false
+		}
+
+		switch string(data[i+4 : i+8]) {
+		case "acTL":
+			return nil, false
+		case "cHRM", "gAMA", "iCCP", "sRGB", "pHYs":
+			keep = append(keep, data[i:end]...)
+		case "IEND":
+			return keep, true
+		}
+
+		i = end
+	}
+
+	return nil, false
+}
@@ -0,0 +1,213 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+// testImage creates an image with some detail, so that it does not
+// compress to almost nothing.
+func testImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.NRGBA{
+				R: uint8(x * 255 / width),
+				G: uint8((x*y)%97 + y),
+				B: uint8((x ^ y) * 3),
+				A: 255,
+			})
+		}
+	}
+
+	return img
+}
+
+func TestOptimizeJPEG(t *testing.T) {
+	rgb := testImage(67, 45)
+	gray := image.NewGray(rgb.Bounds())
+
+	for y := 0; y < 45; y++ {
+		for x := 0; x < 67; x++ {
+			gray.Set(x, y, rgb.At(x, y))
+		}
+	}
+
+	tests := []struct {
+		name string
+		img  image.Image
+	}{
+		{"color", rgb},
+		{"gray", gray},
+	}
+
+	for _, test := range tests {
+		var buf bytes.Buffer
+
+		err := jpeg.Encode(&buf, test.img, &jpeg.Options{Quality: 90})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := buf.Bytes()
+		out, err := recompressJPEG(data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(out) >= len(data) {
+			t.Errorf("%s: Recompressed image is not smaller: %d >= %d bytes.", test.name, len(out), len(data))
+		}
+
+		want, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := jpeg.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Errorf("%s: Recompressed image can not be decoded: %v", test.name, err)
+			continue
+		}
+
+		if !sameImage(got, want) {
+			t.Errorf("%s: Recompressed image differs from the original.", test.name)
+		}
+	}
+}
+
+func TestOptimizeJPEGInvalid(t *testing.T) {
+	var buf bytes.Buffer
+
+	err := jpeg.Encode(&buf, testImage(16, 16), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := buf.Bytes()
+
+	// Truncated image data can not be recompressed, and is returned as is.
+	for _, in := range [][]byte{nil, []byte("not a jpeg"), data[:len(data)/2]} {
+		if out := optimizeJPEG(in); !bytes.Equal(out, in) {
+			t.Errorf("Invalid data of %d bytes was changed.", len(in))
+		}
+	}
+}
+
+// sameImage determines if the given images have the same pixels.
+func sameImage(a, b image.Image) bool {
+	if a.Bounds() != b.Bounds() {
+		return false
+	}
+
+	r := a.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			r1, g1, b1, a1 := a.At(x, y).RGBA()
+			r2, g2, b2, a2 := b.At(x, y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// pngChunk returns a PNG chunk of the given type and data.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 4, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	chunk = append(chunk, typ...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+func TestOptimizePNG(t *testing.T) {
+	var buf bytes.Buffer
+
+	enc := png.Encoder{CompressionLevel: png.NoCompression}
+	err := enc.Encode(&buf, testImage(40, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert chunks after the signature and the IHDR chunk.
+	plain := buf.Bytes()
+	insert := func(chunks ...[]byte) []byte {
+		data := append([]byte{}, plain[:33]...)
+		for _, c := range chunks {
+			data = append(data, c...)
+		}
+		return append(data, plain[33:]...)
+	}
+
+	gamma := pngChunk("gAMA", []byte{0, 0, 0xb1, 0x8f})
+	text := pngChunk("tEXt", []byte("Comment\x00Some text"))
+	anim := pngChunk("acTL", []byte{0, 0, 0, 1, 0, 0, 0, 0})
+
+	tests := []struct {
+		name    string
+		data    []byte
+		changed bool     // Data is expected to be recompressed.
+		keep    [][]byte // Chunks expected in the output.
+		drop    [][]byte // Chunks not expected in the output.
+	}{
+		{"plain", plain, true, nil, nil},
+		{"gamma", insert(gamma, text), true, [][]byte{gamma}, [][]byte{text}},
+		{"animated", insert(anim), false, nil, nil},
+		{"truncated", plain[:len(plain)/2], false, nil, nil},
+		{"invalid", []byte("not a png"), false, nil, nil},
+	}
+
+	for _, test := range tests {
+		out := optimizePNG(test.data)
+
+		if changed := !bytes.Equal(out, test.data); changed != test.changed {
+			t.Errorf("%s: Expected changed=%v, got %v.", test.name, test.changed, changed)
+			continue
+		}
+
+		for _, chunk := range test.keep {
+			if !bytes.Contains(out, chunk) {
+				t.Errorf("%s: Chunk %q was dropped.", test.name, chunk[4:8])
+			}
+		}
+
+		for _, chunk := range test.drop {
+			if bytes.Contains(out, chunk) {
+				t.Errorf("%s: Chunk %q was kept.", test.name, chunk[4:8])
+			}
+		}
+
+		if !test.changed {
+			continue
+		}
+
+		want, err := png.Decode(bytes.NewReader(test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := png.Decode(bytes.NewReader(out))
+		if err != nil {
+			t.Errorf("%s: Recompressed image can not be decoded: %v", test.name, err)
+			continue
+		}
+
+		if !sameImage(got, want) {
+			t.Errorf("%s: Recompressed image differs from the original.", test.name)
+		}
+	}
+}
@@ -16,17 +16,35 @@ type Page struct {
 	lang        string
 	dir         string
 	date        time.Time
+	config      *Config
+	site        *SiteView
+	paginator   *Paginator
 }
 
-// NewPage creates a new page with default settings.
-func NewPage() *Page {
+// NewPage creates a new page for the given site, with default
+// settings taken from the site configuration.
+func NewPage(site *Site) *Page {
+	config := site.Config
+
 	p := new(Page)
-	p.keywords = DefaultKeywords
-	p.lang = DefaultLang
-	p.dir = DefaultDir
+	p.keywords = config.Keywords
+	p.lang = config.Lang
+	p.dir = config.Dir
+	p.config = config
+	p.site = NewSiteView(site)
 	return p
 }
 
+// Config returns the site configuration.
+func (p *Page) Config() *Config { return p.config }
+
+// Site returns a read-only view of the whole site.
+func (p *Page) Site() *SiteView { return p.site }
+
+// Paginator returns the page's position in a listing which spans
+// multiple pages. Returns nil for pages which are not part of one.
+func (p *Page) Paginator() *Paginator { return p.paginator }
+
 // HasDate returns true if the post has a date defined.
 func (p *Page) HasDate() bool { return !p.date.IsZero() }
 
@@ -0,0 +1,94 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"fmt"
+)
+
+// Paginator describes the position of a page in a listing
+// which is split over multiple pages.
+//
+// The first page is served at the listing's own URL. Subsequent pages
+// are served at <dir>/page/<n>/, where <dir> is the directory-style
+// version of the listing's URL.
+type Paginator struct {
+	first   string // URL of the first page.
+	dir     string // Directory-style URL of the first page.
+	current int    // Current page number, starting at 1.
+	total   int    // Total number of pages.
+}
+
+// NewPaginator creates a paginator for page n out of total pages
+// in the listing at the given URL.
+func NewPaginator(url string, n, total int) *Paginator {
+	p := new(Paginator)
+	p.first = url
+	p.dir = URLDir(url)
+	p.current = n
+	p.total = total
+	return p
+}
+
+// Page returns the current page number, starting at 1.
+func (p *Paginator) Page() int { return p.current }
+
+// TotalPages returns the total number of pages.
+func (p *Paginator) TotalPages() int { return p.total }
+
+// HasPrev returns true if there is a page before the current one.
+func (p *Paginator) HasPrev() bool { return p.current > 1 }
+
+// HasNext returns true if there is a page after the current one.
+func (p *Paginator) HasNext() bool { return p.current < p.total }
+
+// Prev returns the URL of the previous page.
+// Returns an empty string if there is none.
+func (p *Paginator) Prev() string {
+	if !p.HasPrev() {
+		return ""
+	}
+	return p.URL(p.current - 1)
+}
+
+// Next returns the URL of the next page.
+// Returns an empty string if there is none.
+func (p *Paginator) Next() string {
+	if !p.HasNext() {
+		return ""
+	}
+	return p.URL(p.current + 1)
+}
+
+// First returns the URL of the first page.
+func (p *Paginator) First() string { return p.URL(1) }
+
+// Last returns the URL of the last page.
+func (p *Paginator) Last() string { return p.URL(p.total) }
+
+// URL returns the URL for page n.
+func (p *Paginator) URL(n int) string {
+	if n <= 1 {
+		return p.first
+	}
+	return fmt.Sprintf("%spage/%d/", p.dir, n)
+}
+
+// Paginate splits the given posts into pages holding at most size
+// posts each. A size of 0 or less puts all posts on a single page.
+// There is always at least one, possibly empty, page.
+func Paginate(posts []*Post, size int) [][]*Post {
+	if size <= 0 || len(posts) <= size {
+		return [][]*Post{posts}
+	}
+
+	pages := make([][]*Post, 0, (len(posts)+size-1)/size)
+
+	for len(posts) > size {
+		pages = append(pages, posts[:size])
+		posts = posts[size:]
+	}
+
+	return append(pages, posts)
+}
@@ -0,0 +1,94 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPaginate(t *testing.T) {
+	posts := make([]*Post, 5)
+	for i := range posts {
+		posts[i] = new(Post)
+	}
+
+	tests := []struct {
+		count, size int
+		want        []int // Number of posts on each page.
+	}{
+		{0, 2, []int{0}},
+		{1, 2, []int{1}},
+		{2, 2, []int{2}},
+		{3, 2, []int{2, 1}},
+		{4, 2, []int{2, 2}},
+		{5, 2, []int{2, 2, 1}},
+		{5, 1, []int{1, 1, 1, 1, 1}},
+		{5, 0, []int{5}},
+		{5, -1, []int{5}},
+	}
+
+	for _, test := range tests {
+		pages := Paginate(posts[:test.count], test.size)
+
+		got := make([]int, len(pages))
+		next := 0
+
+		for i, page := range pages {
+			got[i] = len(page)
+
+			// Pages hold the posts in their original order.
+			for _, post := range page {
+				if post != posts[next] {
+					t.Errorf("%d posts by %d: Page %d holds post %d out of order.", test.count, test.size, i+1, next)
+				}
+				next++
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d posts by %d: have pages %v, want %v", test.count, test.size, got, test.want)
+		}
+	}
+}
+
+func TestPaginator(t *testing.T) {
+	tests := []struct {
+		url              string
+		n, total         int
+		prev, next, last string
+	}{
+		// Single page.
+		{"/posts/", 1, 1, "", "", "/posts/"},
+
+		// First, middle and last page.
+		{"/posts/", 1, 3, "", "/posts/page/2/", "/posts/page/3/"},
+		{"/posts/", 2, 3, "/posts/", "/posts/page/3/", "/posts/page/3/"},
+		{"/posts/", 3, 3, "/posts/page/2/", "", "/posts/page/3/"},
+
+		// Listings which are not served from a directory.
+		{"/tags/go.html", 2, 2, "/tags/go.html", "", "/tags/go/page/2/"},
+		{"/", 2, 2, "/", "", "/page/2/"},
+	}
+
+	for _, test := range tests {
+		p := NewPaginator(test.url, test.n, test.total)
+
+		if p.HasPrev() != (test.prev != "") || p.Prev() != test.prev {
+			t.Errorf("%s page %d/%d: have prev %q, want %q", test.url, test.n, test.total, p.Prev(), test.prev)
+		}
+
+		if p.HasNext() != (test.next != "") || p.Next() != test.next {
+			t.Errorf("%s page %d/%d: have next %q, want %q", test.url, test.n, test.total, p.Next(), test.next)
+		}
+
+		if p.First() != test.url {
+			t.Errorf("%s page %d/%d: have first %q", test.url, test.n, test.total, p.First())
+		}
+
+		if p.Last() != test.last {
+			t.Errorf("%s page %d/%d: have last %q, want %q", test.url, test.n, test.total, p.Last(), test.last)
+		}
+	}
+}
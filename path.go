@@ -19,6 +19,7 @@ const (
 
 // ValidatePath ensures the given path is valid.
 // This means it exists, and contains a few expected sub directories.
+// The deploy directory is created if it does not yet exist.
 //
 // It returns the absolute version of the path or an error.
 func ValidatePath(path string) (string, error) {
@@ -42,26 +43,33 @@ func ValidatePath(path string) (string, error) {
 		return "", err
 	}
 
-	err = dirExists(path, "templates")
+	return path, createDeploy(path)
+}
+
+// CleanDeploy deletes all generated content from the deploy directory
+// for the site at the given path.
+func CleanDeploy(path string) error {
+	err := os.RemoveAll(filepath.Join(path, "deploy"))
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// Delete existing deploy directory.
+	return createDeploy(path)
+}
+
+// createDeploy creates the deploy directory and its
+// posts and tags sub directories.
+func createDeploy(path string) error {
 	deploy := filepath.Join(path, "deploy")
-	err = os.RemoveAll(deploy)
-	if err != nil {
-		return "", err
-	}
 
-	// Create new posts directory.
-	err = os.MkdirAll(filepath.Join(deploy, "posts"), DirPermission)
+	// Create posts directory.
+	err := os.MkdirAll(filepath.Join(deploy, "posts"), DirPermission)
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// Create new tags directory.
-	return path, os.MkdirAll(filepath.Join(deploy, "tags"), DirPermission)
+	// Create tags directory.
+	return os.MkdirAll(filepath.Join(deploy, "tags"), DirPermission)
 }
 
 // dirExists ensures the given path exists and that
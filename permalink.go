@@ -0,0 +1,101 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultPermalink defines the default URL pattern for posts.
+	DefaultPermalink = "/posts/:year/:month/:day/:slug.html"
+
+	// DefaultTagPermalink defines the default URL pattern for tag pages.
+	DefaultTagPermalink = "/tags/:tag/"
+)
+
+var regPermalinkVar = regexp.MustCompile(`:[a-z]+`)
+
+// PostPath returns the site-relative URL for the given post, as
+// defined by the configured permalink pattern. The pattern can hold
+// the following variables:
+//
+//	:year     Four digit year of the post date.
+//	:month    Two digit month of the post date.
+//	:day      Two digit day of the post date.
+//	:slug     The post's slug.
+//	:section  The first directory below 'posts' holding the post source.
+//
+// Patterns ending in a slash yield directory-style URLs.
+func (c *Config) PostPath(p *Post) string {
+	return expandPermalink(c.Permalink, map[string]string{
+		"year":    p.Date.Format("2006"),
+		"month":   p.Date.Format("01"),
+		"day":     p.Date.Format("02"),
+		"slug":    p.Slug(),
+		"section": p.Section(),
+	})
+}
+
+// TagPath returns the site-relative URL for the given tag, as defined by
+// the configured tag permalink pattern. The pattern can hold the :tag
+// variable, which is replaced with the tag slug.
+func (c *Config) TagPath(tag Tag) string {
+	return expandPermalink(c.TagPermalink, map[string]string{
+		"tag": tag.Slug,
+	})
+}
+
+// expandPermalink replaces the variables in the given URL pattern
+// with their values. Unknown variables are left as-is.
+func expandPermalink(pattern string, vars map[string]string) string {
+	url := regPermalinkVar.ReplaceAllStringFunc(pattern, func(name string) string {
+		if value, ok := vars[name[1:]]; ok {
+			return value
+		}
+		return name
+	})
+
+	// Clean up after empty variables, retaining directory-style URLs.
+	clean := path.Clean("/" + url)
+	if strings.HasSuffix(url, "/") && clean != "/" {
+		clean += "/"
+	}
+
+	return clean
+}
+
+// URLDir returns the directory-style URL that corresponds to the
+// given URL. This is where content belonging to a page is stored.
+//
+//	/tags/foo/     -> /tags/foo/
+//	/tags/foo.html -> /tags/foo/
+func URLDir(url string) string {
+	if strings.HasSuffix(url, "/") {
+		return url
+	}
+	return strings.TrimSuffix(url, path.Ext(url)) + "/"
+}
+
+// OutputFile returns the path of the file in the given deploy
+// directory, which serves the given site-relative URL.
+// Directory-style URLs are served by an index.html file.
+func OutputFile(deploy, url string) string {
+	return filepath.Join(deploy, filepath.FromSlash(outputName(url)))
+}
+
+// outputName returns the slash-separated path of the file which serves
+// the given site-relative URL, relative to the deploy directory.
+//
+//	/posts/         -> /posts/index.html
+//	/posts/foo.html -> /posts/foo.html
+func outputName(url string) string {
+	if strings.HasSuffix(url, "/") {
+		url += "index.html"
+	}
+	return path.Clean("/" + url)
+}
@@ -0,0 +1,87 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPostPath(t *testing.T) {
+	tests := []struct {
+		pattern, file, want string
+	}{
+		{DefaultPermalink, "posts/hello.md", "/posts/2014/01/08/hello-world.html"},
+		{"/:year/:slug/", "posts/hello.md", "/2014/hello-world/"},
+		{"/:section/:slug.html", "posts/go/hello.md", "/go/hello-world.html"},
+		{"/:section/:slug.html", "posts/go/hello/index.md", "/go/hello-world.html"},
+
+		// Empty variables do not leave empty path segments behind.
+		{"/:section/:slug.html", "posts/hello.md", "/hello-world.html"},
+		{"/:section/:slug/", "posts/hello.md", "/hello-world/"},
+		{"/blog/:section/", "posts/hello.md", "/blog/"},
+
+		// Unknown variables are kept.
+		{"/:category/:slug.html", "posts/hello.md", "/:category/hello-world.html"},
+	}
+
+	for _, test := range tests {
+		config := NewConfig()
+		config.Permalink = test.pattern
+
+		post := NewPost(config)
+		post.Title = "Hello, World"
+		post.Date = time.Date(2014, 1, 8, 12, 0, 0, 0, time.UTC)
+		post.file = filepath.FromSlash(test.file)
+		post.bundle = filepath.Base(test.file) == "index.md"
+
+		if got := config.PostPath(post); got != test.want {
+			t.Errorf("%s for %s: have %q, want %q", test.pattern, test.file, got, test.want)
+		}
+	}
+}
+
+func TestTagPath(t *testing.T) {
+	tests := []struct {
+		pattern, tag, want string
+	}{
+		{DefaultTagPermalink, "Go", "/tags/go/"},
+		{"/topics/:tag.html", "Web Design", "/topics/web-design.html"},
+		{"/:tag/", "Übersicht", "/ubersicht/"},
+	}
+
+	for _, test := range tests {
+		config := NewConfig()
+		config.TagPermalink = test.pattern
+
+		if got := config.TagPath(NewTag(test.tag)); got != test.want {
+			t.Errorf("%s for %q: have %q, want %q", test.pattern, test.tag, got, test.want)
+		}
+	}
+}
+
+func TestOutputFile(t *testing.T) {
+	tests := []struct {
+		url, want string
+	}{
+		{"/", "/index.html"},
+		{"/posts/", "/posts/index.html"},
+		{"/posts/hello/", "/posts/hello/index.html"},
+		{"/posts/hello.html", "/posts/hello.html"},
+		{"/posts/../hello.html", "/hello.html"},
+		{"sitemap.xml", "/sitemap.xml"},
+	}
+
+	for _, test := range tests {
+		if got := outputName(test.url); got != test.want {
+			t.Errorf("outputName(%q): have %q, want %q", test.url, got, test.want)
+		}
+
+		want := filepath.Join("deploy", filepath.FromSlash(test.want))
+		if got := OutputFile("deploy", test.url); got != want {
+			t.Errorf("OutputFile(%q): have %q, want %q", test.url, got, want)
+		}
+	}
+}
@@ -4,50 +4,42 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
+	"html/template"
+	"path/filepath"
 	"regexp"
 	"strings"
 	"time"
 
-	"github.com/jteeuwen/ini"
+	"github.com/rainycape/unidecode"
 )
 
 const (
 	// TimeFormat represents the timestamp format in post metadata.
 	TimeFormat = "2006-01-02 15:04 MST"
 
+	// DayFormat represents a date without a time in post metadata,
+	// as written by a bare YAML or TOML date. It is taken as UTC.
+	DayFormat = "2006-01-02"
+
 	// DateFormat represents a rendered date
 	DateFormat = "Jan _2, 2006"
 )
 
 var (
-	// DefaultLang defines the default ISO language code which is to
-	// be used for each generated page. This can be overridden by a
-	// command line option and for each post individually through the
-	// use of metadata information.
-	DefaultLang = "en,en-GB"
-
-	// DefaultDir defines the default text direction which is to
-	// be used for each generated page. This can be overridden by a
-	// command line option and for each post individually through the
-	// use of metadata information.
-	DefaultDir = "ltr"
-
-	// DefaultTags defines the default set of tags to be used for each
-	// generated page. This can be overridden by a command line option and for
-	// each post individually through the use of metadata information.
-	DefaultTags = ""
-
-	// DefaultKeywords defines the default set of keywords to be used for each
-	// generated page. This can be overridden by a command line option and for
-	// each post individually through the use of metadata information.
-	DefaultKeywords = ""
-
 	endMeta = []byte("$endmeta")
 	regName = regexp.MustCompile(`[^a-zA-Z0-9-_]`)
 )
 
+// postMetadata lists the meta data keys which are stored in
+// dedicated Post fields. All others end up in Post.Params.
+var postMetadata = map[string]bool{
+	"title": true, "description": true, "keywords": true, "tags": true,
+	"lang": true, "dir": true, "slug": true, "postdate": true,
+	"modified": true, "expires": true, "sitemap": true, "draft": true,
+	"layout": true, "toc": true,
+}
+
 // Post represents a single document/post.
 type Post struct {
 	Content     []byte
@@ -58,61 +50,245 @@ type Post struct {
 	Lang        string
 	Dir         string
 	Date        time.Time
+	Modified    time.Time
+	Expires     time.Time
+	Draft       bool
+	Sitemap     bool
+	Layout      string                 // Template used to render the post.
+	Params      map[string]interface{} // Unrecognised meta data.
+	TOC         []*TOCEntry            // Table of contents.
+	Summary     template.HTML          // Leading part of the content.
+	Truncated   bool                   // Summary is shorter than the content.
+	WordCount   int                    // Number of words in the content.
+	ReadingTime int                    // Estimated reading time in minutes.
+	toc         bool                   // Generate a table of contents.
+	links       []*Post                // Posts linked to from the content.
+	lines       map[string]int         // Line numbers of meta data keys.
+	assets      []string               // Files copied next to the output.
+	bundle      bool                   // Source is the index of a page bundle.
+	slug        string                 // Explicit slug, from meta data.
+	tags        string                 // Default tags.
+	file        string                 // Source file, relative to the site root.
+	hash        string                 // Hash of the source file contents.
 }
 
-// NewPost creates a new, empty post with default settings.
-func NewPost() *Post {
+// NewPost creates a new, empty post with default settings
+// taken from the given site configuration.
+func NewPost(config *Config) *Post {
 	p := new(Post)
-	p.Keywords = DefaultKeywords
-	p.Lang = DefaultLang
-	p.Dir = DefaultDir
+	p.Keywords = config.Keywords
+	p.Lang = config.Lang
+	p.Dir = config.Dir
+	p.tags = config.Tags
+	p.Sitemap = true
+	p.toc = true
+	p.Params = make(map[string]interface{})
 	return p
 }
 
-// SafePath creates a relative file path using the post's title and post date.
-//
-//    yyyy/mm/dd/title.html
-//
-// The value is returned as the directory path and the file name.
-func (p *Post) SafePath() (string, string) {
-	dir := p.Date.Format("2006/01/02")
-	file := strings.ToLower(p.Title)
-	file = strings.Replace(file, " ", "-", -1)
-	file = regName.ReplaceAllString(file, "")
-	file = fmt.Sprintf("%s.html", file)
+// Slug returns the name identifying the post in its URL. This is the
+// value of the 'slug' metadata key, if set. Otherwise, it is derived
+// from the post title, or from the source file name if the title yields
+// an empty slug. The source file name of a page bundle is the name of
+// its directory.
+func (p *Post) Slug() string {
+	if len(p.slug) > 0 {
+		return p.slug
+	}
 
-	// Trim duplicate -
-	for strings.Index(file, "--") > -1 {
-		file = strings.Replace(file, "--", "-", -1)
+	slug := Slugify(p.Title)
+	if len(slug) == 0 {
+		name := filepath.Base(p.file)
+		if p.bundle {
+			name = filepath.Base(filepath.Dir(p.file))
+		}
+		slug = Slugify(strings.TrimSuffix(name, filepath.Ext(name)))
 	}
 
-	p.Path = strings.Join([]string{"", "posts", dir, file}, "/")
-	return dir, file
+	return slug
+}
+
+// Section returns the name of the first directory below 'posts'
+// which holds the post source. Returns an empty string for posts
+// stored directly in 'posts'. A page bundle counts as a single file.
+func (p *Post) Section() string {
+	file := p.file
+	if p.bundle {
+		file = filepath.Dir(file)
+	}
+
+	parts := strings.Split(filepath.ToSlash(file), "/")
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
 }
 
 // ReadMetadata reads post meta data from the given slice.
 // It returns any remaining data and tags specified in the document.
+//
+// Meta data can be supplied as a YAML block delimited by '---' lines,
+// a TOML block delimited by '+++' lines, or an INI block terminated
+// by the '$endmeta' marker.
+//
+// Invalid values do not stop the remaining ones from being read. All of
+// them are returned as an ErrorList, along with their line numbers.
 func (p *Post) ReadMetadata(data []byte) ([]byte, string, error) {
-	index := bytes.Index(data, endMeta)
-	if index == -1 {
+	lines, offset := metaLines(data)
+
+	meta, data, err := readMetadata(data)
+	if err != nil {
+		line, msg := errorLine(err, offset)
+		return data, "", newFileError(p.file, line, "%s", msg)
+	}
+
+	if meta == nil {
 		return data, "", nil
 	}
 
-	ini := ini.New()
-	err := ini.LoadBytes(data[:index])
-	if err != nil {
-		return data, "", err
+	p.lines = lines
+	p.Title = meta.S("title", p.Title)
+	p.Description = meta.S("description", p.Description)
+	p.Keywords = meta.S("keywords", p.Keywords)
+	p.Lang = meta.S("lang", p.Lang)
+	p.Dir = meta.S("dir", p.Dir)
+	p.slug = Slugify(meta.S("slug", p.slug))
+	p.Layout = meta.S("layout", p.Layout)
+
+	for key, value := range meta {
+		if !postMetadata[key] {
+			p.Params[key] = normalizeValue(value)
+		}
+	}
+
+	var errs ErrorList
+
+	if !validDir(p.Dir) {
+		errs = append(errs, newFileError(p.file, p.line("dir"),
+			"Invalid value %q for dir: expected ltr, rtl or auto.", p.Dir))
+	}
+
+	dates := []struct {
+		key   string
+		value *time.Time
+	}{
+		{"postdate", &p.Date},
+		{"modified", &p.Modified},
+		{"expires", &p.Expires},
+	}
+
+	for _, d := range dates {
+		t, err := meta.T(d.key, *d.value)
+		if err != nil {
+			errs = append(errs, newFileError(p.file, p.line(d.key),
+				"Invalid value %q for %s: expected a date like %q or %q.",
+				meta.S(d.key, ""), d.key, TimeFormat, DayFormat))
+			continue
+		}
+
+		*d.value = t
+	}
+
+	flags := []struct {
+		key   string
+		value *bool
+	}{
+		{"sitemap", &p.Sitemap},
+		{"toc", &p.toc},
+		{"draft", &p.Draft},
 	}
 
-	section := ini.Section("") // Global section
+	for _, f := range flags {
+		b, err := meta.B(f.key, *f.value)
+		if err != nil {
+			errs = append(errs, newFileError(p.file, p.line(f.key),
+				"Invalid value %q for %s: expected true or false.",
+				meta.S(f.key, ""), f.key))
+			continue
+		}
 
-	p.Title = section.S("title", p.Title)
-	p.Description = section.S("description", p.Description)
-	p.Keywords = section.S("keywords", p.Keywords)
-	p.Lang = section.S("lang", p.Lang)
-	p.Dir = section.S("dir", p.Dir)
-	p.Date, err = time.Parse(TimeFormat,
-		section.S("postdate", p.Date.Format(TimeFormat)))
+		*f.value = b
+	}
+
+	if len(errs) > 0 {
+		errs.Sort()
+		return data, "", errs
+	}
+
+	return data, meta.S("tags", p.tags), nil
+}
+
+// line returns the line number of the given meta data key in the post
+// source. Returns 0 if the key was not set.
+func (p *Post) line(key string) int {
+	return p.lines[key]
+}
+
+// slugLine returns the line number of the meta data key which determines
+// the post's slug. Returns 0 if neither 'slug' nor 'title' was set.
+func (p *Post) slugLine() int {
+	if line := p.line("slug"); line > 0 {
+		return line
+	}
+	return p.line("title")
+}
+
+// Template returns the name of the template used to render the post.
+// This is the value of the 'layout' metadata key, or defval if it is
+// not set. The .html extension may be omitted from the layout.
+func (p *Post) Template(defval string) string {
+	if len(p.Layout) == 0 {
+		return defval
+	}
+
+	if len(filepath.Ext(p.Layout)) == 0 {
+		return p.Layout + ".html"
+	}
+
+	return p.Layout
+}
+
+// LastModified returns the time the post was last modified.
+// This is the post date, unless a later modification date is known.
+func (p *Post) LastModified() time.Time {
+	if p.Modified.After(p.Date) {
+		return p.Modified
+	}
+	return p.Date
+}
+
+// IsPublished determines if the post should be published at the given time.
+// If not, it returns a human readable reason for it.
+func (p *Post) IsPublished(now time.Time, config *Config) (bool, string) {
+	if p.Draft && !config.Drafts {
+		return false, "draft"
+	}
+
+	if p.Date.After(now) && !config.Future {
+		return false, fmt.Sprintf("scheduled for %s", p.Date.Format(TimeFormat))
+	}
+
+	if !p.Expires.IsZero() && !p.Expires.After(now) && !config.Expired {
+		return false, fmt.Sprintf("expired on %s", p.Expires.Format(TimeFormat))
+	}
+
+	return true, ""
+}
+
+// Slugify turns the given text into a URL-safe, lower case name.
+// Non-ASCII characters are transliterated into their closest ASCII
+// equivalents. Anything which is not a letter, digit, '-' or '_'
+// is dropped, except for whitespace, which is turned into '-'.
+func Slugify(text string) string {
+	slug := unidecode.Unidecode(text)
+	slug = strings.ToLower(slug)
+	slug = strings.Join(strings.Fields(slug), "-")
+	slug = regName.ReplaceAllString(slug, "")
+
+	// Trim duplicate -
+	for strings.Index(slug, "--") > -1 {
+		slug = strings.Replace(slug, "--", "-", -1)
+	}
 
-	return data[index+len(endMeta):], section.S("tags", DefaultTags), err
+	return strings.Trim(slug, "-")
 }
@@ -0,0 +1,99 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPostIsPublished(t *testing.T) {
+	now := time.Date(2014, 1, 8, 12, 0, 0, 0, time.UTC)
+	past := now.Add(-time.Hour)
+	future := now.Add(time.Hour)
+
+	tests := []struct {
+		name                 string
+		draft                bool
+		date, expires        time.Time
+		drafts, fut, expired bool // Configuration switches.
+		want                 bool
+		reason               string
+	}{
+		{"published", false, past, time.Time{}, false, false, false, true, ""},
+		{"draft", true, past, time.Time{}, false, false, false, false, "draft"},
+		{"draft included", true, past, time.Time{}, true, false, false, true, ""},
+		{"scheduled", false, future, time.Time{}, false, false, false, false, "scheduled for 2014-01-08 13:00 UTC"},
+		{"scheduled included", false, future, time.Time{}, false, true, false, true, ""},
+		{"due now", false, now, time.Time{}, false, false, false, true, ""},
+		{"expired", false, past, past, false, false, false, false, "expired on 2014-01-08 11:00 UTC"},
+		{"expiring now", false, past, now, false, false, false, false, "expired on 2014-01-08 12:00 UTC"},
+		{"expiring later", false, past, future, false, false, false, true, ""},
+		{"expired included", false, past, past, false, false, true, true, ""},
+		{"draft and scheduled", true, future, time.Time{}, false, false, false, false, "draft"},
+	}
+
+	for _, test := range tests {
+		config := NewConfig()
+		config.Drafts = test.drafts
+		config.Future = test.fut
+		config.Expired = test.expired
+
+		post := NewPost(config)
+		post.Draft = test.draft
+		post.Date = test.date
+		post.Expires = test.expires
+
+		ok, reason := post.IsPublished(now, config)
+		if ok != test.want || reason != test.reason {
+			t.Errorf("%s: have %v %q, want %v %q", test.name, ok, reason, test.want, test.reason)
+		}
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Leading and   trailing  ", "leading-and-trailing"},
+		{"snake_case and kebab-case", "snake_case-and-kebab-case"},
+		{"A -- B", "a-b"},
+		{"Ça va, Zoë?", "ca-va-zoe"},
+		{"Straße", "strasse"},
+		{"Привет мир", "privet-mir"},
+		{"日本語", "ri-ben-yu"},
+		{"!?", ""},
+	}
+
+	for _, test := range tests {
+		if got := Slugify(test.in); got != test.want {
+			t.Errorf("Slugify(%q): have %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestPostSlug(t *testing.T) {
+	tests := []struct {
+		slug, title, file, want string
+	}{
+		{"", "Über uns", "posts/about.md", "uber-uns"},
+		{"custom", "Über uns", "posts/about.md", "custom"},
+		{"", "???", "posts/Über uns.md", "uber-uns"},
+		{"", "", "posts/2014/notes/index.md", "notes"},
+	}
+
+	for _, test := range tests {
+		post := NewPost(NewConfig())
+		post.slug = test.slug
+		post.Title = test.title
+		post.file = filepath.FromSlash(test.file)
+		post.bundle = filepath.Base(test.file) == "index.md"
+
+		if got := post.Slug(); got != test.want {
+			t.Errorf("Slug of %q (%s): have %q, want %q", test.title, test.file, got, test.want)
+		}
+	}
+}
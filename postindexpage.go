@@ -11,6 +11,10 @@ type PostIndexEntry struct {
 	Title       template.HTML
 	Description template.HTMLAttr
 	Path        template.HTMLAttr
+	Summary     template.HTML
+	Truncated   bool
+	WordCount   int
+	ReadingTime int
 }
 
 type PostIndex struct {
@@ -25,28 +29,32 @@ type PostIndexPage struct {
 }
 
 // NewPostIndexPage returns a new PostIndexPage for the given site.
-func NewPostIndexPage(site *Site) *PostIndexPage {
+// It lists the given posts, which are expected to be sorted by date.
+func NewPostIndexPage(site *Site, posts []*Post, pager *Paginator) *PostIndexPage {
 	p := new(PostIndexPage)
-	p.Page = NewPage()
+	p.Page = NewPage(site)
 	p.Page.title = "Listing of posts"
 	p.Page.description = p.Page.title
 	p.Page.keywords = "posts, archive, history, index"
+	p.Page.paginator = pager
 
-	if len(site.Posts) == 0 {
+	if len(posts) == 0 {
 		return p
 	}
 
-	p.Years = make([]*PostIndex, 0, len(site.Posts))
+	p.Years = make([]*PostIndex, 0, len(posts))
 
-	PostsByDate(site.Posts).Sort()
-
-	for _, post := range site.Posts {
+	for _, post := range posts {
 		index := p.getIndex(post.Date.Year())
 
 		index.Posts = append(index.Posts, &PostIndexEntry{
 			Title:       template.HTML(post.Title),
 			Description: template.HTMLAttr(post.Description),
 			Path:        template.HTMLAttr(post.Path),
+			Summary:     post.Summary,
+			Truncated:   post.Truncated,
+			WordCount:   post.WordCount,
+			ReadingTime: post.ReadingTime,
 		})
 	}
 
@@ -12,13 +12,18 @@ type PostPage struct {
 	*Page
 	tags    []Tag
 	content []byte
+	params  map[string]interface{}
+	toc     []*TOCEntry
+	prev    *Post
+	next    *Post
+	related []*Post
 }
 
 // NewPostPage returns a new PostPage for the given post
 // and tags.
-func NewPostPage(post *Post, tags ...Tag) *PostPage {
+func NewPostPage(site *Site, post *Post, tags ...Tag) *PostPage {
 	p := new(PostPage)
-	p.Page = NewPage()
+	p.Page = NewPage(site)
 	p.Page.keywords = post.Keywords
 	p.Page.title = post.Title
 	p.Page.description = post.Description
@@ -26,10 +31,32 @@ func NewPostPage(post *Post, tags ...Tag) *PostPage {
 	p.Page.dir = post.Dir
 	p.Page.date = post.Date
 	p.content = post.Content
+	p.params = post.Params
+	p.toc = post.TOC
 	p.tags = tags
 	return p
 }
 
 func (p *PostPage) Content() template.HTML { return template.HTML(string(p.content)) }
 func (p *PostPage) HasTags() bool          { return len(p.tags) > 0 }
-func (p *PostPage) Tags() template.HTML    { return RenderTags(p.tags) }
+func (p *PostPage) Tags() template.HTML    { return RenderTags(p.tags, p.config) }
+
+// HasTOC returns true if the post has a table of contents.
+func (p *PostPage) HasTOC() bool { return len(p.toc) > 0 }
+
+// TOC returns the post's table of contents.
+func (p *PostPage) TOC() []*TOCEntry { return p.toc }
+
+// Prev returns the post published before this one.
+// Returns nil if there is none.
+func (p *PostPage) Prev() *Post { return p.prev }
+
+// Next returns the post published after this one.
+// Returns nil if there is none.
+func (p *PostPage) Next() *Post { return p.next }
+
+// Related returns posts on similar subjects.
+func (p *PostPage) Related() []*Post { return p.related }
+
+// Params returns the post's custom meta data fields.
+func (p *PostPage) Params() map[string]interface{} { return p.params }
@@ -0,0 +1,126 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// relatedPost is a candidate in a list of related posts.
+type relatedPost struct {
+	post       *Post
+	tags       int     // Number of shared tags.
+	similarity float64 // Similarity of the post contents.
+}
+
+// RelatedPosts finds at most n related posts for every post. Posts are
+// related if they share at least one tag. They are ranked by the number
+// of tags they share, then by the similarity of their contents, and
+// finally by date.
+func (s *Site) RelatedPosts(n int) map[*Post][]*Post {
+	related := make(map[*Post][]*Post, len(s.Posts))
+	if n <= 0 {
+		return related
+	}
+
+	// Collect the posts for each tag, and the tags for each post.
+	tagPosts := make(map[string][]*Post)
+	postTags := make(map[*Post][]string)
+
+	for _, c := range s.Connections {
+		tagPosts[c.Tag.Slug] = append(tagPosts[c.Tag.Slug], c.Post)
+		postTags[c.Post] = append(postTags[c.Post], c.Tag.Slug)
+	}
+
+	words := make(map[*Post]map[string]bool, len(s.Posts))
+	for _, post := range s.Posts {
+		words[post] = contentWords(post)
+	}
+
+	for _, post := range s.Posts {
+		shared := make(map[*Post]int)
+
+		for _, tag := range postTags[post] {
+			for _, other := range tagPosts[tag] {
+				if other != post {
+					shared[other]++
+				}
+			}
+		}
+
+		list := make([]*relatedPost, 0, len(shared))
+		for other, count := range shared {
+			list = append(list, &relatedPost{
+				post:       other,
+				tags:       count,
+				similarity: similarity(words[post], words[other]),
+			})
+		}
+
+		sort.Slice(list, func(i, j int) bool {
+			a, b := list[i], list[j]
+			if a.tags != b.tags {
+				return a.tags > b.tags
+			}
+			if a.similarity != b.similarity {
+				return a.similarity > b.similarity
+			}
+			if !a.post.Date.Equal(b.post.Date) {
+				return a.post.Date.After(b.post.Date)
+			}
+			return a.post.Path < b.post.Path
+		})
+
+		if len(list) > n {
+			list = list[:n]
+		}
+
+		posts := make([]*Post, len(list))
+		for i, r := range list {
+			posts[i] = r.post
+		}
+
+		related[post] = posts
+	}
+
+	return related
+}
+
+// contentWords returns the set of distinct words in the given post's
+// content. Short words are left out, as they say little about the
+// subject of a post.
+func contentWords(post *Post) map[string]bool {
+	text := strings.ToLower(stripHTML(string(post.Content)))
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	set := make(map[string]bool, len(fields))
+	for _, word := range fields {
+		if len(word) > 3 {
+			set[word] = true
+		}
+	}
+
+	return set
+}
+
+// similarity computes the Jaccard index of the given word sets.
+// This is a value between 0 (nothing in common) and 1 (identical).
+func similarity(a, b map[string]bool) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	var common int
+	for word := range a {
+		if b[word] {
+			common++
+		}
+	}
+
+	return float64(common) / float64(len(a)+len(b)-common)
+}
@@ -0,0 +1,249 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// WatchInterval defines how often the preview server checks
+	// the site sources for changes.
+	WatchInterval = 500 * time.Millisecond
+
+	// reloadPath is the URL path of the live reload event stream.
+	reloadPath = "/_sitebuild/reload"
+)
+
+// reloadScript is injected into every served HTML page. It reloads
+// the page whenever the site has been rebuilt.
+const reloadScript = `<script>
+(function() {
+	var es = new EventSource("` + reloadPath + `");
+	es.onmessage = function() { location.reload(); };
+})();
+</script>`
+
+var errorPage = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+ <head><title>Build failed</title></head>
+ <body>
+  <h1>Build failed</h1>
+  <pre>{{.}}</pre>
+ </body>
+</html>
+`))
+
+// watchSources lists the paths, relative to the site root, which
+// are watched for changes by the preview server.
+var watchSources = []string{
+	"posts", "static", "templates", "themes", "index.md", ConfigFile,
+}
+
+// server serves a site's generated output and rebuilds it
+// whenever its sources change.
+type server struct {
+	root    string
+	lock    sync.RWMutex // Guards err and the deploy directory.
+	err     error        // Error from the most recent build.
+	clients map[chan struct{}]struct{}
+	clock   sync.Mutex // Guards clients.
+}
+
+// Serve builds the site at the given root path and serves it over HTTP
+// on the given address. The site is rebuilt when its sources change.
+func Serve(root, addr string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	s := new(server)
+	s.root = root
+	s.clients = make(map[chan struct{}]struct{})
+	s.rebuild()
+
+	go s.watch()
+
+	fmt.Printf("Serving %s on http://%s/\n", root, addr)
+	return http.ListenAndServe(addr, s)
+}
+
+// ServeHTTP serves generated files, the live reload event stream, or
+// the error from the most recent build.
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == reloadPath {
+		s.serveEvents(w, r)
+		return
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.err != nil {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		s.writeHTML(w, s.renderError(s.err))
+		return
+	}
+
+	deploy := filepath.Join(s.root, "deploy")
+	file := filepath.Join(deploy, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+
+	if stat, err := os.Stat(file); err == nil && stat.IsDir() {
+		file = filepath.Join(file, "index.html")
+	}
+
+	if filepath.Ext(file) != ".html" {
+		http.FileServer(http.Dir(deploy)).ServeHTTP(w, r)
+		return
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.writeHTML(w, data)
+}
+
+// writeHTML writes the given HTML document, with the live reload
+// script injected into it.
+func (s *server) writeHTML(w http.ResponseWriter, data []byte) {
+	index := bytes.LastIndex(data, []byte("</body>"))
+	if index == -1 {
+		index = len(data)
+	}
+
+	w.Write(data[:index])
+	w.Write([]byte(reloadScript))
+	w.Write(data[index:])
+}
+
+// renderError renders the given build error as an HTML page.
+func (s *server) renderError(err error) []byte {
+	var buf bytes.Buffer
+	errorPage.Execute(&buf, err.Error())
+	return buf.Bytes()
+}
+
+// serveEvents streams a server-sent event to the client
+// every time the site is rebuilt.
+func (s *server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	c := make(chan struct{}, 1)
+
+	s.clock.Lock()
+	s.clients[c] = struct{}{}
+	s.clock.Unlock()
+
+	defer func() {
+		s.clock.Lock()
+		delete(s.clients, c)
+		s.clock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-c:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// notify tells all connected clients to reload.
+func (s *server) notify() {
+	s.clock.Lock()
+	defer s.clock.Unlock()
+
+	for c := range s.clients {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// rebuild regenerates the site and records the outcome.
+func (s *server) rebuild() {
+	s.lock.Lock()
+	_, s.err = build(s.root, false)
+	s.lock.Unlock()
+
+	if s.err != nil {
+		warn("Build failed: %v\n", s.err)
+	} else {
+		fmt.Printf("Site rebuilt at %s.\n", time.Now().Format("15:04:05"))
+	}
+}
+
+// watch periodically checks the site sources for changes and
+// rebuilds the site when it finds any.
+func (s *server) watch() {
+	last := s.sourceHash()
+
+	for range time.Tick(WatchInterval) {
+		hash := s.sourceHash()
+		if hash == last {
+			continue
+		}
+
+		last = hash
+		s.rebuild()
+		s.notify()
+	}
+}
+
+// sourceHash computes a hash over the names, sizes and modification
+// times of all site sources.
+func (s *server) sourceHash() uint64 {
+	h := fnv.New64a()
+
+	for _, name := range watchSources {
+		filepath.Walk(filepath.Join(s.root, name),
+			func(file string, stat os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+
+				if strings.HasPrefix(stat.Name(), ".") {
+					if stat.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
+				fmt.Fprintf(h, "%s %d %d\n", file, stat.Size(), stat.ModTime().UnixNano())
+				return nil
+			})
+	}
+
+	return h.Sum64()
+}
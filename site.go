@@ -4,14 +4,19 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
 	"html/template"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
-
-	"github.com/jteeuwen/blackfriday"
+	"time"
 )
 
 // Connection represents a connection between a tag and a post.
@@ -22,30 +27,62 @@ type Connection struct {
 
 // Site holds a site's posts, tags and templates.
 type Site struct {
-	Posts       []*Post            // List of site posts.
-	Tags        []Tag              // List of unique tags referenced by posts.
-	Connections []Connection       // Bindings, connecting a post to a given tag.
-	templates   *template.Template // Tree of all site templates.
-	Root        string             // Root path for the site.
+	Posts       []*Post                       // List of site posts.
+	Tags        []Tag                         // List of unique tags referenced by posts.
+	Connections []Connection                  // Bindings, connecting a post to a given tag.
+	templates   map[string]*template.Template // Site templates, by name.
+	Config      *Config                       // Site-wide settings.
+	Root        string                        // Root path for the site.
+	now         time.Time                     // Time at which the site was loaded.
+	manifest    *Manifest                     // Inputs of generated files.
+	optimizer   *Optimizer                    // Minifies and compresses output.
+	highlighter *Highlighter                  // Highlights code blocks.
+	configHash  string                        // Hash of the site configuration.
+	templHash   map[string]string             // Hash of each page template and the shared ones.
+	siteHash    string                        // Hash of all posts.
+	usesSite    map[string]bool               // Page templates which refer to the site model.
+	failed      map[string]bool               // Post sources which failed to load.
 }
 
-// LoadSite loads a new set for the given root path.
-func LoadSite(root string) (*Site, error) {
+// LoadSite loads a new set for the given root path and configuration.
+// The manifest is used to determine which files need to be regenerated.
+func LoadSite(root string, config *Config, manifest *Manifest) (*Site, error) {
 	s := new(Site)
 	s.Root = root
+	s.Config = config
+	s.now = time.Now()
+	s.manifest = manifest
+	s.optimizer = NewOptimizer(config)
+	s.configHash = hashConfig(config)
 
-	// Load templates.
-	err := s.loadTemplates()
+	var err error
+
+	s.highlighter, err = NewHighlighter(config)
 	if err != nil {
 		return nil, err
 	}
 
-	// Load posts.
-	err = s.loadPosts()
+	// Load templates.
+	err = s.loadTemplates()
 	if err != nil {
 		return nil, err
 	}
 
+	// Load posts, and make sure they do not overwrite each other
+	// or their assets. Links to other posts are pointed at their
+	// generated URLs. All problems found are reported at once.
+	var errs ErrorList
+	errs = appendError(errs, s.loadPosts())
+	errs = appendError(errs, s.checkPaths())
+	errs = appendError(errs, s.checkAssets())
+	errs = appendError(errs, s.resolveLinks())
+
+	if len(errs) > 0 {
+		errs.Sort()
+		return nil, errs
+	}
+
+	s.siteHash = s.hashPosts()
 	return s, nil
 }
 
@@ -53,9 +90,8 @@ func LoadSite(root string) (*Site, error) {
 func (s *Site) PostCount(tag Tag) int {
 	var count int
 
-	name := string(tag)
 	for _, c := range s.Connections {
-		if strings.EqualFold(string(c.Tag), name) {
+		if c.Tag.Equal(tag) {
 			count++
 		}
 	}
@@ -66,10 +102,9 @@ func (s *Site) PostCount(tag Tag) int {
 // FindPosts finds all posts associated with the given tag.
 func (s *Site) FindPosts(tag Tag) []*Post {
 	list := make([]*Post, 0, 2)
-	name := string(tag)
 
 	for _, c := range s.Connections {
-		if strings.EqualFold(string(c.Tag), name) {
+		if c.Tag.Equal(tag) {
 			list = append(list, c.Post)
 		}
 	}
@@ -91,9 +126,8 @@ func (s *Site) FindTags(post *Post) []Tag {
 }
 
 func containsTag(list []Tag, tag Tag) bool {
-	name := string(tag)
 	for _, t := range list {
-		if strings.EqualFold(string(t), name) {
+		if t.Equal(tag) {
 			return true
 		}
 	}
@@ -112,44 +146,167 @@ func (s *Site) PostIndex(p *Post) int {
 }
 
 // TagIndex returns the index for the given tag.
-// Returns -1 if it was not found. This performs a case-insensitive compare.
+// Returns -1 if it was not found. Tags are compared by their slugs.
 func (s *Site) TagIndex(t Tag) int {
-	name := string(t)
 	for i, tag := range s.Tags {
-		if strings.EqualFold(string(tag), name) {
+		if tag.Equal(t) {
 			return i
 		}
 	}
 	return -1
 }
 
+// Inputs returns the inputs for a page which is generated from the
+// given posts, using the named page template. This includes the site
+// template and configuration, as well as the posts linked to from the
+// given ones. The name is empty for files which are not rendered from
+// a template, like feeds.
+//
+// If the page template refers to the site model, the page depends
+// on all posts.
+func (s *Site) Inputs(name string, posts ...*Post) Inputs {
+	in := make(Inputs, len(posts)+3)
+	in["config"] = s.configHash
+
+	if len(name) > 0 {
+		in["templates"] = s.templHash[name]
+	}
+
+	if s.usesSite[name] {
+		in["site"] = s.siteHash
+	}
+
+	for _, post := range posts {
+		in[post.file] = post.hash
+
+		for _, link := range post.links {
+			in[link.file] = link.hash
+		}
+	}
+
+	return in
+}
+
+// hashConfig computes a hash over the settings which affect generated
+// output. Settings which only affect how the site is built are left out,
+// so that changing them does not regenerate every page.
+func hashConfig(config *Config) string {
+	c := *config
+	c.Jobs = 0
+	c.Strict = false
+	c.BrokenLinks = ""
+	return hashBytes([]byte(fmt.Sprintf("%#v", c)))
+}
+
+// hashPosts computes a hash over the sources of all posts.
+func (s *Site) hashPosts() string {
+	hash := sha1.New()
+
+	for _, post := range s.Posts {
+		fmt.Fprintf(hash, "%s %s\n", post.file, post.hash)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
 // Render renders a page using the specified template.
-// Output is written to the given writer.
+// Output is optimized and written to the given writer.
 func (s *Site) Render(w io.Writer, name string, page interface{}) error {
-	return s.templates.ExecuteTemplate(w, name, page)
+	var buf bytes.Buffer
+
+	t, ok := s.templates[name]
+	if !ok {
+		return newError("Template %q does not exist.", name)
+	}
+
+	err := t.ExecuteTemplate(&buf, name, page)
+	if err != nil {
+		return err
+	}
+
+	data, err := s.optimizer.Optimize(name, buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
 }
 
-// loadPosts loads all posts.
+// loadPosts loads all posts. These are the Markdown files in the posts
+// directory and its sub directories. A directory holding an index.md
+// file is loaded as a single page bundle. Hidden files are ignored.
+//
+// Posts with invalid contents do not stop the others from being loaded.
+// Their problems are returned together, as an ErrorList.
 func (s *Site) loadPosts() error {
+	var errs ErrorList
+
+	// Sources which fail to load are recorded, so that
+	// links to them are not reported as well.
+	s.failed = make(map[string]bool)
+	load := func(file string, bundle bool) error {
+		err := s.loadPost(file, bundle)
+		if err != nil {
+			rel, _ := filepath.Rel(s.Root, file)
+			s.failed[filepath.ToSlash(rel)] = true
+		}
+		return err
+	}
+
 	path := filepath.Join(s.Root, "posts")
-	return filepath.Walk(path, func(file string, stat os.FileInfo, err error) error {
-		if err != nil || stat.IsDir() {
+	err := filepath.Walk(path, func(file string, stat os.FileInfo, err error) error {
+		if err != nil {
 			return err
 		}
-		return s.loadPost(file)
+
+		if file != path && isHidden(stat.Name()) {
+			if stat.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if stat.IsDir() {
+			if file == path || !isBundle(file) {
+				return nil
+			}
+
+			errs = appendError(errs, load(filepath.Join(file, BundleIndex), true))
+			return filepath.SkipDir
+		}
+
+		if isMarkdown(file) {
+			errs = appendError(errs, load(file, false))
+		}
+
+		return nil
 	})
+
+	if err != nil {
+		return err
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
 }
 
 // loadPost loads post data from the given file.
 // This also loads unique tags.
-func (s *Site) loadPost(file string) error {
+func (s *Site) loadPost(file string, bundle bool) error {
 	// Read post data from file.
 	data, err := ioutil.ReadFile(file)
 	if err != nil {
 		return err
 	}
 
-	post := NewPost()
+	post := NewPost(s.Config)
+	post.file, _ = filepath.Rel(s.Root, file)
+	post.hash = hashBytes(data)
+	post.bundle = bundle
 
 	// Check if we have meta data.
 	data, tags, err := post.ReadMetadata(data)
@@ -157,33 +314,88 @@ func (s *Site) loadPost(file string) error {
 		return err
 	}
 
+	if len(strings.TrimSpace(post.Title)) == 0 {
+		line := post.line("title")
+		if line == 0 {
+			line = 1
+		}
+		return newFileError(post.file, line, "Post has no title.")
+	}
+
+	err = s.checkLayout(post)
+	if err != nil {
+		return err
+	}
+
+	// Leave out drafts, scheduled and expired posts.
+	if ok, reason := post.IsPublished(s.now, s.Config); !ok {
+		warn("%s: Skipping post: %s.\n", post.file, reason)
+		return nil
+	}
+
+	post.assets, err = findAssets(s.Root, post)
+	if err != nil {
+		return err
+	}
+
 	// Parse content as markdown.
-	post.Content = blackfriday.MarkdownCommon(data)
+	post.Content, err = s.Markdown(data, post.file)
+	if err != nil {
+		return err
+	}
+
+	plain := post.Content
+	post.Content, post.TOC = addHeadingIDs(post.Content, s.Config)
+	if !post.toc {
+		post.TOC = nil
+	}
+
+	s.summarize(post, plain)
+
+	post.Path = s.Config.PostPath(post)
 
 	// Add post to list.
 	s.Posts = append(s.Posts, post)
 
 	// Parse tags and create connection with current post.
-	s.parseTags(tags, post)
-	return nil
+	return s.parseTags(tags, post)
 }
 
 // parseTags reads tags from the given string.
 // Tags are added to the set's tag list, provided they are unique.
 //
 // Additionally, it creates a binding between a tag and the given post.
-func (s *Site) parseTags(value string, post *Post) {
+// Tags without a usable name are reported.
+func (s *Site) parseTags(value string, post *Post) error {
 	names := toList(value)
 	if len(names) == 0 {
-		return
+		return nil
 	}
 
+	var errs ErrorList
+
+	seen := make([]Tag, 0, len(names))
+
 	for _, name := range names {
-		tagIndex := s.TagIndex(Tag(name))
+		tag := NewTag(name)
+		if len(tag.Slug) == 0 {
+			errs = appendError(errs, s.report(newFileError(post.file, post.line("tags"),
+				"Ignoring tag %q, as it has no usable name.", name)))
+			continue
+		}
+
+		// Skip tags listed more than once.
+		if containsTag(seen, tag) {
+			continue
+		}
+
+		seen = append(seen, tag)
+
+		tagIndex := s.TagIndex(tag)
 
 		if tagIndex == -1 {
 			tagIndex = len(s.Tags)
-			s.Tags = append(s.Tags, Tag(strings.ToLower(name)))
+			s.Tags = append(s.Tags, tag)
 		}
 
 		s.Connections = append(s.Connections, Connection{
@@ -191,29 +403,364 @@ func (s *Site) parseTags(value string, post *Post) {
 			Post: post,
 		})
 	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
 }
 
-// loadTemplates loads all templates.
-func (s *Site) loadTemplates() error {
-	path := filepath.Join(s.Root, "templates")
-	fd, err := os.Open(path)
-	if err != nil {
+// report reports a problem which does not stop the site from being built.
+// It is shown as a warning and nil is returned, unless the site is built
+// in strict mode, in which case the problem is returned as an error.
+func (s *Site) report(err error) error {
+	if s.Config.Strict {
 		return err
 	}
 
-	defer fd.Close()
+	warn("%v\n", err)
+	return nil
+}
 
-	files, err := fd.Readdirnames(-1)
+// findLine returns the number of the first line in the given file,
+// relative to the site root, for which match returns true. Returns 0
+// if there is none.
+func (s *Site) findLine(file string, match func(line string) bool) int {
+	data, err := ioutil.ReadFile(filepath.Join(s.Root, file))
 	if err != nil {
+		return 0
+	}
+
+	for n, line := range strings.Split(string(data), "\n") {
+		if match(line) {
+			return n + 1
+		}
+	}
+
+	return 0
+}
+
+// linkLine returns the number of the line in the given Markdown source
+// which links to the given target, either inline, as in [text](target),
+// or through a reference definition, as in [id]: target. Returns 0 if
+// there is none.
+func (s *Site) linkLine(file, link string) int {
+	if len(link) == 0 {
+		return 0
+	}
+
+	target := regexp.QuoteMeta(link)
+	inline := regexp.MustCompile(`\]\(\s*<?` + target + `(?:[\s)>]|$)`)
+	reference := regexp.MustCompile(`^\s{0,3}\[[^\]]+\]:\s*<?` + target + `(?:[\s>]|$)`)
+
+	return s.findLine(file, func(line string) bool {
+		return inline.MatchString(line) || reference.MatchString(line)
+	})
+}
+
+// checkPaths ensures that no two posts are written to the same output
+// file, and that no post overwrites a page generated by the site itself.
+// It returns an error listing every collision.
+func (s *Site) checkPaths() error {
+	generated := s.generatedFiles()
+	files := make(map[string][]*Post, len(s.Posts))
+
+	for _, post := range s.Posts {
+		name := outputName(post.Path)
+		files[name] = append(files[name], post)
+	}
+
+	var errs ErrorList
+
+	for _, post := range s.Posts {
+		name := outputName(post.Path)
+		list := files[name]
+
+		// Report each group once, when we encounter its first post.
+		if list[0] != post {
+			continue
+		}
+
+		if page, ok := generated[name]; ok {
+			for _, p := range list {
+				errs = append(errs, newFileError(p.file, p.slugLine(),
+					"Post is written to %s, which holds %s.", name, page))
+			}
+			continue
+		}
+
+		for i := range list {
+			for j := i + 1; j < len(list); j++ {
+				errs = append(errs, newFileError(list[j].file, list[j].slugLine(),
+					"Post is written to %s, as is %s.", name, list[i].file))
+			}
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	return errs
+}
+
+// listing describes a paginated listing of posts generated by the site.
+type listing struct {
+	url   string  // URL of the first page.
+	size  int     // Number of posts per page.
+	posts []*Post // Posts in the listing.
+	desc  string  // Description of the listing.
+}
+
+// listings returns the paginated listings generated by the site itself:
+// the front page, the post index and the page for each tag.
+func (s *Site) listings() []listing {
+	list := []listing{
+		{"/", s.Config.IndexPageSize, s.Posts, "the front page"},
+		{"/posts/", s.Config.PageSize, s.Posts, "the post index"},
+	}
+
+	for _, tag := range s.Tags {
+		desc := fmt.Sprintf("the page for tag %q", tag.Name)
+		list = append(list, listing{s.Config.TagPath(tag), s.Config.TagPageSize, s.FindPosts(tag), desc})
+	}
+
+	return list
+}
+
+// URLs returns the URLs of all pages of the listing.
+func (l listing) URLs() []string {
+	pages := Paginate(l.posts, l.size)
+	urls := make([]string, len(pages))
+
+	for i := range pages {
+		urls[i] = NewPaginator(l.url, i+1, len(pages)).URL(i + 1)
+	}
+
+	return urls
+}
+
+// generatedFiles returns the output files of the pages, feeds and
+// other files generated by the site itself, relative to the deploy
+// directory. Each maps onto a description of its contents.
+func (s *Site) generatedFiles() map[string]string {
+	files := make(map[string]string)
+
+	for _, l := range s.listings() {
+		for _, url := range l.URLs() {
+			files[outputName(url)] = l.desc
+		}
+	}
+
+	files[outputName("/tags/")] = "the tag index"
+	files["/feed.atom"] = "the site feed"
+	files["/feed.rss"] = "the site feed"
+	files["/sitemap.xml"] = "the sitemap"
+	files["/robots.txt"] = "robots.txt"
+	files[HighlightFile] = "the highlighting stylesheet"
+
+	for _, tag := range s.Tags {
+		dir := URLDir(s.Config.TagPath(tag))
+		files[dir+"feed.atom"] = fmt.Sprintf("the feed for tag %q", tag.Name)
+		files[dir+"feed.rss"] = fmt.Sprintf("the feed for tag %q", tag.Name)
+	}
+
+	return files
+}
+
+// loadTemplates loads all templates from the site's templates directory,
+// including its sub directories. Templates are named after their path,
+// relative to this directory: "post.html", "partials/header.html", etc.
+//
+// If a theme is configured, its templates are used for every name the
+// site does not define itself.
+//
+// Templates in sub directories, like partials and layouts, are shared
+// by all pages. Page templates, those directly in the templates directory,
+// are each parsed into their own copy of the shared set, so that they can
+// override the blocks of a base layout without affecting each other.
+func (s *Site) loadTemplates() error {
+	files := make(map[string]string)
+
+	if len(s.Config.Theme) > 0 {
+		dir := filepath.Join(s.Root, "themes", s.Config.Theme, "templates")
+		err := findTemplates(dir, files)
+		if err != nil {
+			return newError("Theme %q: %v", s.Config.Theme, err)
+		}
+	}
+
+	err := findTemplates(filepath.Join(s.Root, "templates"), files)
+	if err != nil && !(os.IsNotExist(err) && len(files) > 0) {
 		return err
 	}
 
-	for i := range files {
-		files[i] = filepath.Join(path, files[i])
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
 	}
 
-	s.templates, err = template.ParseFiles(files...)
-	return err
+	sort.Strings(names)
+
+	hash := sha1.New()
+	sources := make(map[string]string, len(names))
+	set := template.New("").Funcs(templateFuncs(s))
+
+	for _, name := range names {
+		data, err := ioutil.ReadFile(files[name])
+		if err != nil {
+			return err
+		}
+
+		sources[name] = string(data)
+
+		if !isPageTemplate(name) {
+			hash.Write([]byte(name))
+			hash.Write(data)
+
+			_, err = set.New(name).Parse(sources[name])
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	// Each page depends on its own template, and on the shared
+	// templates its copy of the set was made from.
+	shared := hash.Sum(nil)
+
+	s.templates = make(map[string]*template.Template, len(names))
+	s.templHash = make(map[string]string)
+	s.usesSite = make(map[string]bool)
+
+	for _, name := range names {
+		t := set
+		if isPageTemplate(name) {
+			hash := sha1.New()
+			hash.Write(shared)
+			hash.Write([]byte(name))
+			hash.Write([]byte(sources[name]))
+
+			s.templHash[name] = hex.EncodeToString(hash.Sum(nil))
+			s.usesSite[name] = refersToSite(name, sources)
+
+			t, err = set.Clone()
+			if err != nil {
+				return err
+			}
+
+			_, err = t.New(name).Parse(sources[name])
+			if err != nil {
+				return err
+			}
+		}
+
+		s.templates[name] = t
+	}
+
+	return nil
+}
+
+var (
+	regTemplateUse = regexp.MustCompile(`\{\{-?\s*(?:template|block)\s+"([^"]+)"`)
+	regTemplateDef = regexp.MustCompile(`\{\{-?\s*(?:define|block)\s+"([^"]+)"`)
+)
+
+// refersToSite determines if the named page template refers to the site
+// model, either itself, or through the layouts and partials it includes.
+// This may give false positives, which merely cost us some unnecessary
+// rebuilds.
+func refersToSite(name string, sources map[string]string) bool {
+	// Find the files defining each template the page can include.
+	// Other page templates are not part of its set.
+	files := make(map[string][]string)
+	for file, data := range sources {
+		if isPageTemplate(file) && file != name {
+			continue
+		}
+
+		files[file] = append(files[file], file)
+		for _, m := range regTemplateDef.FindAllStringSubmatch(data, -1) {
+			files[m[1]] = append(files[m[1]], file)
+		}
+	}
+
+	seen := make(map[string]bool)
+	list := []string{name}
+
+	for len(list) > 0 {
+		file := list[0]
+		list = list[1:]
+
+		if seen[file] {
+			continue
+		}
+
+		seen[file] = true
+
+		if strings.Contains(sources[file], ".Site") {
+			return true
+		}
+
+		for _, m := range regTemplateUse.FindAllStringSubmatch(sources[file], -1) {
+			list = append(list, files[m[1]]...)
+		}
+	}
+
+	return false
+}
+
+// checkLayout ensures that the template set by the post's 'layout'
+// metadata key exists.
+func (s *Site) checkLayout(post *Post) error {
+	if len(post.Layout) == 0 {
+		return nil
+	}
+
+	name := post.Template("")
+	if _, ok := s.templates[name]; ok && isPageTemplate(name) {
+		return nil
+	}
+
+	return newFileError(post.file, post.line("layout"),
+		"Invalid value %q for layout: there is no template %q.", post.Layout, name)
+}
+
+// isPageTemplate determines if the template with the given name renders
+// a page, rather than being a partial or layout shared by other templates.
+func isPageTemplate(name string) bool {
+	return !strings.Contains(name, "/")
+}
+
+// findTemplates adds all templates in the given directory and its
+// sub directories to the given map, keyed by their slash-separated
+// path relative to dir. Hidden files and directories are ignored.
+func findTemplates(dir string, files map[string]string) error {
+	return filepath.Walk(dir, func(file string, stat os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if file != dir && strings.HasPrefix(stat.Name(), ".") {
+			if stat.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if stat.IsDir() {
+			return nil
+		}
+
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return err
+		}
+
+		files[filepath.ToSlash(name)] = file
+		return nil
+	})
 }
 
 // toList splits the given value and omits empty entries.
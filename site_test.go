@@ -0,0 +1,74 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckPaths(t *testing.T) {
+	post := func(file, path string) *Post {
+		p := NewPost(NewConfig())
+		p.file = filepath.FromSlash(file)
+		p.Path = path
+		p.lines = map[string]int{"title": 2}
+		return p
+	}
+
+	tests := []struct {
+		name  string
+		posts []*Post
+		want  []string
+	}{
+		{"unique", []*Post{
+			post("posts/a.md", "/posts/a.html"),
+			post("posts/b.md", "/posts/b/"),
+		}, nil},
+		{"pair", []*Post{
+			post("posts/a.md", "/posts/a/"),
+			post("posts/b.md", "/posts/c.html"),
+			post("posts/c.md", "/posts/a/index.html"),
+		}, []string{
+			"posts/c.md:2: Post is written to /posts/a/index.html, as is posts/a.md.",
+		}},
+		{"three", []*Post{
+			post("posts/a.md", "/x.html"),
+			post("posts/b.md", "/x.html"),
+			post("posts/c.md", "/x.html"),
+		}, []string{
+			"posts/b.md:2: Post is written to /x.html, as is posts/a.md.",
+			"posts/c.md:2: Post is written to /x.html, as is posts/a.md.",
+			"posts/c.md:2: Post is written to /x.html, as is posts/b.md.",
+		}},
+		{"generated", []*Post{
+			post("posts/a.md", "/posts/"),
+			post("posts/b.md", "/sitemap.xml"),
+		}, []string{
+			"posts/a.md:2: Post is written to /posts/index.html, which holds the post index.",
+			"posts/b.md:2: Post is written to /sitemap.xml, which holds the sitemap.",
+		}},
+	}
+
+	for _, test := range tests {
+		s := new(Site)
+		s.Config = NewConfig()
+		s.Posts = test.posts
+
+		var got []string
+		if err := s.checkPaths(); err != nil {
+			errs := err.(ErrorList)
+			errs.Sort()
+
+			for _, e := range errs {
+				got = append(got, filepath.ToSlash(e.Error()))
+			}
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: have errors %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SitemapSize defines the maximum number of URLs in a single sitemap.
+// Sites with more URLs get a sitemap index, referencing multiple sitemaps.
+const SitemapSize = 50000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type sitemapURLSet struct {
+	XMLName xml.Name      `xml:"urlset"`
+	XMLNS   string        `xml:"xmlns,attr"`
+	URLs    []*sitemapURL `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name      `xml:"sitemapindex"`
+	XMLNS    string        `xml:"xmlns,attr"`
+	Sitemaps []*sitemapURL `xml:"sitemap"`
+}
+
+// WriteSitemap generates an XML sitemap listing all pages on the site.
+// Posts can be left out with the 'sitemap' metadata key.
+//
+// It additionally generates a robots.txt file referencing the sitemap,
+// unless the site supplies its own in the static directory.
+func WriteSitemap(site *Site) error {
+	dst := filepath.Join(site.Root, "deploy")
+	urls := sitemapURLs(site)
+	inputs := site.Inputs("", site.Posts...)
+
+	if len(urls) <= SitemapSize {
+		err := writeSitemap(site, filepath.Join(dst, "sitemap.xml"), inputs,
+			&sitemapURLSet{XMLNS: sitemapNamespace, URLs: urls})
+		if err != nil {
+			return err
+		}
+
+		return writeRobots(site)
+	}
+
+	index := &sitemapIndex{XMLNS: sitemapNamespace}
+
+	for n := 0; len(urls) > 0; n++ {
+		size := SitemapSize
+		if size > len(urls) {
+			size = len(urls)
+		}
+
+		name := fmt.Sprintf("sitemap-%d.xml", n+1)
+		err := writeSitemap(site, filepath.Join(dst, name), inputs,
+			&sitemapURLSet{XMLNS: sitemapNamespace, URLs: urls[:size]})
+		if err != nil {
+			return err
+		}
+
+		index.Sitemaps = append(index.Sitemaps, &sitemapURL{
+			Loc:     site.Config.AbsURL(name),
+			LastMod: lastMod(urls[:size]),
+		})
+
+		urls = urls[size:]
+	}
+
+	err := writeSitemap(site, filepath.Join(dst, "sitemap.xml"), inputs, index)
+	if err != nil {
+		return err
+	}
+
+	return writeRobots(site)
+}
+
+// writeSitemap writes the given sitemap or sitemap index,
+// provided its inputs changed since the previous build.
+func writeSitemap(site *Site, path string, inputs Inputs, v interface{}) error {
+	if !site.manifest.Stale(path, inputs) {
+		return nil
+	}
+	return writeXML(path, v)
+}
+
+// writeRobots writes a robots.txt file which references the sitemap.
+func writeRobots(site *Site) error {
+	_, err := os.Stat(filepath.Join(site.Root, "static", "robots.txt"))
+	if err == nil {
+		return nil
+	}
+
+	path := filepath.Join(site.Root, "deploy", "robots.txt")
+	if !site.manifest.Stale(path, site.Inputs("")) {
+		return nil
+	}
+
+	data := fmt.Sprintf("User-agent: *\nAllow: /\n\nSitemap: %s\n",
+		site.Config.AbsURL("sitemap.xml"))
+	return ioutil.WriteFile(path, []byte(data), FilePermission)
+}
+
+// sitemapURLs lists the URLs for all pages on the site.
+func sitemapURLs(site *Site) []*sitemapURL {
+	posts := recentPosts(site.Posts, 0)
+	latest := sitemapDate(posts)
+	c := site.Config
+
+	urls := make([]*sitemapURL, 0, len(posts)+len(site.Tags)+3)
+
+	// Every page of a listing changes along with its posts.
+	for _, l := range site.listings() {
+		date := sitemapDate(l.posts)
+		for _, url := range l.URLs() {
+			urls = append(urls, &sitemapURL{Loc: c.AbsURL(url), LastMod: date})
+		}
+	}
+
+	urls = append(urls, &sitemapURL{Loc: c.AbsURL("/tags/"), LastMod: latest})
+
+	for _, post := range posts {
+		if !post.Sitemap {
+			continue
+		}
+
+		urls = append(urls, &sitemapURL{
+			Loc:     c.AbsURL(post.Path),
+			LastMod: formatLastMod(post.LastModified()),
+		})
+	}
+
+	return urls
+}
+
+// sitemapDate returns the most recent modification date for the
+// given posts, formatted for use in a sitemap.
+func sitemapDate(posts []*Post) string {
+	var latest time.Time
+
+	for _, post := range posts {
+		if t := post.LastModified(); t.After(latest) {
+			latest = t
+		}
+	}
+
+	return formatLastMod(latest)
+}
+
+// lastMod returns the most recent modification date in the given URLs.
+func lastMod(urls []*sitemapURL) string {
+	var latest string
+
+	// Dates share the same format and time zone,
+	// so they can be compared as strings.
+	for _, url := range urls {
+		if url.LastMod > latest {
+			latest = url.LastMod
+		}
+	}
+
+	return latest
+}
+
+// formatLastMod formats the given time for use in a sitemap.
+// Returns an empty string for a zero time.
+func formatLastMod(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
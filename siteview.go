@@ -0,0 +1,96 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+// SiteView is a read-only view of the site model.
+// It is exposed to every page template as {{.Site}}.
+type SiteView struct {
+	site *Site
+}
+
+// TagCount describes a tag, along with the number of posts which
+// reference it and the URL of its tag page.
+type TagCount struct {
+	Tag
+	Path  string
+	Count int
+}
+
+// PostYear holds all posts published in a given year.
+type PostYear struct {
+	Year  int
+	Posts []*Post
+}
+
+// NewSiteView creates a new view of the given site.
+func NewSiteView(site *Site) *SiteView {
+	v := new(SiteView)
+	v.site = site
+	return v
+}
+
+// Title returns the site title.
+func (v *SiteView) Title() string { return v.site.Config.Title }
+
+// URL returns the base URL of the site.
+func (v *SiteView) URL() string { return v.site.Config.URL }
+
+// Param returns the value of the user-defined parameter with the
+// given name. Returns an empty string if it is not set.
+func (v *SiteView) Param(name string) string { return v.site.Config.Param(name) }
+
+// Params returns all user-defined parameters.
+func (v *SiteView) Params() map[string]string {
+	params := make(map[string]string, len(v.site.Config.Params))
+	for key, value := range v.site.Config.Params {
+		params[key] = value
+	}
+	return params
+}
+
+// Posts returns all posts, sorted by date. Most recent first.
+func (v *SiteView) Posts() []*Post { return recentPosts(v.site.Posts, 0) }
+
+// RecentPosts returns at most n of the most recent posts.
+func (v *SiteView) RecentPosts(n int) []*Post { return recentPosts(v.site.Posts, n) }
+
+// PostCount returns the number of posts.
+func (v *SiteView) PostCount() int { return len(v.site.Posts) }
+
+// Tags returns all tags, sorted by name, along with their post counts.
+func (v *SiteView) Tags() []*TagCount {
+	tags := make([]Tag, len(v.site.Tags))
+	copy(tags, v.site.Tags)
+	TagsByName(tags).Sort()
+
+	list := make([]*TagCount, len(tags))
+	for i, tag := range tags {
+		list[i] = &TagCount{
+			Tag:   tag,
+			Path:  v.site.Config.TagPath(tag),
+			Count: v.site.PostCount(tag),
+		}
+	}
+
+	return list
+}
+
+// Years returns all posts, grouped by the year in which they were
+// published. Most recent first.
+func (v *SiteView) Years() []*PostYear {
+	var list []*PostYear
+
+	for _, post := range v.Posts() {
+		year := post.Date.Year()
+
+		if len(list) == 0 || list[len(list)-1].Year != year {
+			list = append(list, &PostYear{Year: year})
+		}
+
+		last := list[len(list)-1]
+		last.Posts = append(last.Posts, post)
+	}
+
+	return list
+}
@@ -23,10 +23,24 @@ func (p PostsByTitle) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
 func (p PostsByTitle) Less(i, j int) bool { return p[i].Title < p[j].Title }
 func (p PostsByTitle) Sort()              { sort.Sort(p) }
 
-// TagsByName sorts tags by name -- ascending
+// TagsByName sorts tags by slug -- ascending
 type TagsByName []Tag
 
 func (p TagsByName) Len() int           { return len(p) }
 func (p TagsByName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
-func (p TagsByName) Less(i, j int) bool { return p[i] < p[j] }
+func (p TagsByName) Less(i, j int) bool { return p[i].Slug < p[j].Slug }
 func (p TagsByName) Sort()              { sort.Sort(p) }
+
+// recentPosts returns at most n of the most recent posts in the given list.
+// The input list is not modified.
+func recentPosts(posts []*Post, n int) []*Post {
+	list := make([]*Post, len(posts))
+	copy(list, posts)
+	PostsByDate(list).Sort()
+
+	if n > 0 && len(list) > n {
+		list = list[:n]
+	}
+
+	return list
+}
@@ -0,0 +1,63 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"strings"
+)
+
+const (
+	// MoreMarker separates a post's summary from the rest of its content.
+	// It must be on a line of its own.
+	MoreMarker = "<!--more-->"
+
+	// moreSentinel takes the place of the MoreMarker in rendered content.
+	moreSentinel = "<!--sitebuild:more-->"
+
+	// WordsPerMinute defines the reading speed used to estimate
+	// reading times.
+	WordsPerMinute = 200
+)
+
+// summarize computes the summary, word count and reading time for the
+// given post, from its rendered content. Words are counted in plain,
+// which is the content as rendered, before headings were changed.
+//
+// The summary is the part of the content before the MoreMarker, which
+// is then removed from the content. Without a marker, the summary holds
+// the first words of the post, as many as defined by the SummaryLength
+// setting.
+func (s *Site) summarize(post *Post, plain []byte) {
+	words := strings.Fields(stripHTML(string(plain)))
+
+	post.WordCount = len(words)
+	post.ReadingTime = (post.WordCount + WordsPerMinute - 1) / WordsPerMinute
+
+	if index := bytes.Index(post.Content, []byte(moreSentinel)); index > -1 {
+		rest := post.Content[index+len(moreSentinel):]
+
+		post.Summary = template.HTML(bytes.TrimSpace(post.Content[:index]))
+		post.Truncated = len(bytes.TrimSpace(rest)) > 0
+		post.Content = append(post.Content[:index:index], rest...)
+		return
+	}
+
+	if len(words) == 0 {
+		return
+	}
+
+	if n := s.Config.SummaryLength; n > 0 && len(words) > n {
+		words = words[:n]
+		post.Truncated = true
+	}
+
+	text := template.HTMLEscapeString(strings.Join(words, " "))
+	if post.Truncated {
+		text += "…"
+	}
+
+	post.Summary = template.HTML("<p>" + text + "</p>")
+}
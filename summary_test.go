@@ -0,0 +1,48 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSummaryMoreMarker(t *testing.T) {
+	s := testSite(t)
+	src := "## Intro\n\nSee [the docs][docs].\n\n<!--more-->\n\nMore text.\n\n[docs]: http://example.com/docs\n"
+
+	post := new(Post)
+
+	var err error
+	post.Content, err = s.Markdown([]byte(src), "summary.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plain := post.Content
+	post.Content, _ = addHeadingIDs(post.Content, s.Config)
+	s.summarize(post, plain)
+
+	summary := string(post.Summary)
+
+	// The summary is cut from the rendered post, so reference links
+	// defined after the marker and heading IDs both apply to it.
+	for _, want := range []string{`href="http://example.com/docs"`, `id="intro"`} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("Summary does not contain %s:\n%s", want, summary)
+		}
+	}
+
+	if strings.Contains(summary, "More text") {
+		t.Errorf("Summary holds text after the marker:\n%s", summary)
+	}
+
+	if !post.Truncated {
+		t.Error("Post with text after the marker is not truncated.")
+	}
+
+	if strings.Contains(string(post.Content), moreSentinel) {
+		t.Errorf("Content still holds the marker:\n%s", post.Content)
+	}
+}
@@ -9,11 +9,42 @@ import (
 	"strings"
 )
 
+// tagReplacer spells out characters which carry meaning in tag names,
+// but would otherwise be dropped from tag slugs. This keeps tags like
+// 'C', 'C++' and 'C#' apart.
+var tagReplacer = strings.NewReplacer(
+	"+", " plus ",
+	"#", " sharp ",
+	"&", " and ",
+	"@", " at ",
+	"/", " ",
+	".", " ",
+)
+
 // Tag represents a tag (surprise!).
-type Tag string
+type Tag struct {
+	Name string // Display name, with its original casing.
+	Slug string // URL-safe name.
+}
+
+// NewTag creates a new tag with the given display name.
+func NewTag(name string) Tag {
+	name = strings.TrimSpace(name)
+	return Tag{
+		Name: name,
+		Slug: Slugify(tagReplacer.Replace(name)),
+	}
+}
+
+// String returns the tag's display name.
+func (t Tag) String() string { return t.Name }
+
+// Equal returns true if both tags refer to the same thing.
+// Tags are compared by their slugs.
+func (t Tag) Equal(other Tag) bool { return t.Slug == other.Slug }
 
-// RenderTags renders the given set of tags.
-func RenderTags(tags []Tag) template.HTML {
+// RenderTags renders the given set of tags as links to their tag pages.
+func RenderTags(tags []Tag, config *Config) template.HTML {
 	if len(tags) == 0 {
 		return template.HTML("")
 	}
@@ -21,9 +52,10 @@ func RenderTags(tags []Tag) template.HTML {
 	html := make([]string, 0, len(tags))
 
 	for _, tag := range tags {
+		name := template.HTMLEscapeString(tag.Name)
 		html = append(html,
-			fmt.Sprintf(`<a href="/tags/%s/" title="Other posts in tag: %s">%s</a>`,
-				tag, tag, tag))
+			fmt.Sprintf(`<a href="%s" title="Other posts in tag: %s">%s</a>`,
+				template.HTMLEscapeString(config.TagPath(tag)), name, name))
 	}
 
 	return template.HTML(strings.Join(html, ", "))
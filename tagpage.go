@@ -5,49 +5,29 @@ package main
 
 import (
 	"fmt"
-	"html/template"
 )
 
 // TagPage represents a page, displaying posts.
 type TagPage struct {
 	*Page
-	site *Site
-	tag  Tag
+	tag   Tag
+	posts []*Post
 }
 
-// NewTagPage returns a new TagPage for the given post
-// and tags.
-func NewTagPage(tag Tag, site *Site) *TagPage {
+// NewTagPage returns a new TagPage for the given tag.
+// It lists the given posts, which are expected to be sorted by date.
+func NewTagPage(tag Tag, site *Site, posts []*Post, pager *Paginator) *TagPage {
 	p := new(TagPage)
-	p.Page = NewPage()
+	p.Page = NewPage(site)
 	p.Page.keywords = fmt.Sprintf("%s, tags, archive, posts, history", tag)
 	p.Page.title = fmt.Sprintf("Posts in tag: %s", tag)
 	p.Page.description = fmt.Sprintf("Listing of posts in tag: %s", tag)
+	p.Page.paginator = pager
 	p.tag = tag
-	p.site = site
+	p.posts = posts
 	return p
 }
 
 func (p *TagPage) Tag() Tag { return p.tag }
 
-func (p *TagPage) Posts(tag Tag) []*Post {
-	posts := p.site.FindPosts(tag)
-	PostsByDate(posts).Sort()
-	return posts
-}
-
-func (p *TagPage) PostDate(post *Post) string {
-	return post.Date.Format(DateFormat)
-}
-
-func (p *TagPage) PostDescription(post *Post) template.HTMLAttr {
-	return template.HTMLAttr(post.Description)
-}
-
-func (p *TagPage) PostTitle(post *Post) template.HTML {
-	return template.HTML(post.Title)
-}
-
-func (p *TagPage) PostPath(post *Post) template.HTMLAttr {
-	return template.HTMLAttr(post.Path)
-}
+func (p *TagPage) Posts() []*Post { return p.posts }
@@ -0,0 +1,114 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"regexp"
+)
+
+var (
+	regHeading = regexp.MustCompile(`(?s)<h([1-6])([^>]*)>(.*?)</h[1-6]>`)
+	regID      = regexp.MustCompile(`\sid="([^"]*)"`)
+)
+
+// TOCEntry is a single heading in a table of contents.
+type TOCEntry struct {
+	Level    int           // Heading level, 1 through 6.
+	ID       string        // ID of the heading element.
+	Title    template.HTML // Contents of the heading.
+	Children []*TOCEntry   // Sub headings.
+}
+
+// addHeadingIDs gives every heading in the given HTML a unique ID,
+// derived from its text. Headings which already have an ID keep it.
+// If enabled in the configuration, a permalink anchor is added to
+// each heading.
+//
+// It returns the modified HTML and a table of contents listing all
+// headings up to the configured depth.
+func addHeadingIDs(data []byte, config *Config) ([]byte, []*TOCEntry) {
+	var toc []*TOCEntry
+	var stack []*TOCEntry
+	used := make(map[string]int)
+
+	// Reserve explicit IDs first, so that generated IDs do not clash
+	// with those of later headings.
+	for _, m := range regHeading.FindAllSubmatch(data, -1) {
+		if sm := regID.FindSubmatch(m[2]); sm != nil {
+			used[string(sm[1])]++
+		}
+	}
+
+	data = regHeading.ReplaceAllFunc(data, func(heading []byte) []byte {
+		m := regHeading.FindSubmatch(heading)
+		level := int(m[1][0] - '0')
+		attr, title := m[2], m[3]
+
+		var id string
+		if sm := regID.FindSubmatch(attr); sm != nil {
+			id = string(sm[1])
+		} else {
+			id = uniqueID(Slugify(stripHTML(string(title))), used)
+			attr = append([]byte(fmt.Sprintf(` id="%s"`, id)), attr...)
+		}
+
+		if level <= config.TOCDepth {
+			entry := &TOCEntry{Level: level, ID: id, Title: template.HTML(title)}
+
+			// Find the closest preceding heading of a lower level.
+			for len(stack) > 0 && stack[len(stack)-1].Level >= level {
+				stack = stack[:len(stack)-1]
+			}
+
+			if len(stack) == 0 {
+				toc = append(toc, entry)
+			} else {
+				parent := stack[len(stack)-1]
+				parent.Children = append(parent.Children, entry)
+			}
+
+			stack = append(stack, entry)
+		}
+
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "<h%d%s>%s", level, attr, title)
+
+		if config.HeadingAnchors {
+			fmt.Fprintf(&buf, ` <a class="anchor" href="#%s" title="Link to this section">#</a>`,
+				template.HTMLEscapeString(id))
+		}
+
+		fmt.Fprintf(&buf, "</h%d>", level)
+		return buf.Bytes()
+	})
+
+	return data, toc
+}
+
+// uniqueID returns the given ID, with a numeric suffix if it has
+// been used before. Empty IDs are replaced with "section".
+func uniqueID(id string, used map[string]int) string {
+	if len(id) == 0 {
+		id = "section"
+	}
+
+	for {
+		n := used[id]
+		used[id]++
+
+		if n == 0 {
+			return id
+		}
+
+		// Skip suffixes taken by explicit IDs.
+		suffixed := fmt.Sprintf("%s-%d", id, n)
+		if used[suffixed] == 0 {
+			used[suffixed]++
+			return suffixed
+		}
+	}
+}
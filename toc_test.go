@@ -0,0 +1,41 @@
+// This file is subject to a 1-clause BSD license.
+// Its contents can be found in the enclosed LICENSE file.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHeadingIDs(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"<h2>Intro</h2><h2>Intro</h2>", []string{"intro", "intro-1"}},
+		{"<h2></h2><h3>!</h3>", []string{"section", "section-1"}},
+		{`<h2 id="setup">Install</h2><h2>Setup</h2>`, []string{"setup", "setup-1"}},
+
+		// Explicit IDs of later headings are not taken by generated ones.
+		{`<h2>Setup</h2><h2 id="setup">Install</h2>`, []string{"setup-1", "setup"}},
+		{`<h2>Setup</h2><h2>Setup</h2><h2 id="setup-1">Install</h2>`, []string{"setup", "setup-2", "setup-1"}},
+	}
+
+	config := NewConfig()
+
+	for _, test := range tests {
+		out, _ := addHeadingIDs([]byte(test.in), config)
+
+		var ids []string
+		for _, m := range regHeading.FindAllSubmatch(out, -1) {
+			if sm := regID.FindSubmatch(m[2]); sm != nil {
+				ids = append(ids, string(sm[1]))
+			}
+		}
+
+		if !reflect.DeepEqual(ids, test.want) {
+			t.Errorf("%s: have IDs %q, want %q", test.in, ids, test.want)
+		}
+	}
+}